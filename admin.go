@@ -0,0 +1,145 @@
+package main
+
+import (
+	"container/heap"
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"time"
+)
+
+func (s *Server) requireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.AdminKey == "" {
+			writeError(w, http.StatusForbidden, codeAdminDisabled, "Admin API disabled")
+			return
+		}
+		key := r.Header.Get("X-Admin-Key")
+		if subtle.ConstantTimeCompare([]byte(key), []byte(s.cfg.AdminKey)) != 1 {
+			writeError(w, http.StatusUnauthorized, codeUnauthorized, "Unauthorized")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) gcHandler(w http.ResponseWriter, r *http.Request) {
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	start := time.Now()
+	runtime.GC()
+	debug.FreeOSMemory()
+	elapsed := time.Since(start)
+	runtime.ReadMemStats(&after)
+
+	logger.Printf("Admin GC from %s: heap %d -> %d bytes in %s\n", r.RemoteAddr, before.HeapAlloc, after.HeapAlloc, elapsed)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"heap_alloc_before": before.HeapAlloc,
+		"heap_alloc_after":  after.HeapAlloc,
+		"freed":             int64(before.HeapAlloc) - int64(after.HeapAlloc),
+		"duration_ms":       elapsed.Milliseconds(),
+	})
+}
+
+func (s *Server) configHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.cfg.redacted())
+}
+
+// envHandler describes the process for support triage. Command-line flags and
+// environment variables are deliberately left out since they may carry
+// secrets such as -admin-key; GET /api/admin/config has the redacted config.
+// Like the other admin endpoints it does not count towards request stats.
+func (s *Server) envHandler(w http.ResponseWriter, r *http.Request) {
+	hostname, _ := os.Hostname()
+	env := map[string]interface{}{
+		"hostname":   hostname,
+		"pid":        os.Getpid(),
+		"go_version": runtime.Version(),
+		"os":         runtime.GOOS,
+		"arch":       runtime.GOARCH,
+		"gomaxprocs": runtime.GOMAXPROCS(0),
+		"num_cpu":    runtime.NumCPU(),
+		"goroutines": runtime.NumGoroutine(),
+		"started":    s.started.UTC(),
+		"uptime_s":   int64(time.Since(s.started).Seconds()),
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		build := map[string]string{"module": info.Main.Path, "version": info.Main.Version}
+		for _, bs := range info.Settings {
+			switch bs.Key {
+			case "vcs.revision", "vcs.time", "vcs.modified":
+				build[bs.Key] = bs.Value
+			}
+		}
+		env["build"] = build
+	}
+	writeJSON(w, http.StatusOK, env)
+}
+
+type keySize struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+}
+
+// sizeHeap is a min-heap by size, so the smallest of the current top N is
+// the one to evict.
+type sizeHeap []keySize
+
+func (h sizeHeap) Len() int            { return len(h) }
+func (h sizeHeap) Less(i, j int) bool  { return h[i].Size < h[j].Size }
+func (h sizeHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *sizeHeap) Push(x interface{}) { *h = append(*h, x.(keySize)) }
+func (h *sizeHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// largestHandler serves GET /api/admin/largest?n=, the n keys (10 by
+// default, at most maxPageLimit) with the largest values, largest first. One
+// pass under the lock keeps a bounded heap of n entries, so memory stays
+// O(n) however big the store is.
+func (s *Server) largestHandler(w http.ResponseWriter, r *http.Request) {
+	n := 10
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidParameter, "n must be a positive integer")
+			return
+		}
+		if n > maxPageLimit {
+			n = maxPageLimit
+		}
+	}
+
+	now := time.Now()
+	h := make(sizeHeap, 0, n)
+	s.lock()
+	s.data.Range(func(k string, e entry) bool {
+		if e.expired(now) {
+			return true
+		}
+		if h.Len() < n {
+			heap.Push(&h, keySize{Key: k, Size: len(e.Value)})
+		} else if len(e.Value) > h[0].Size {
+			h[0] = keySize{Key: k, Size: len(e.Value)}
+			heap.Fix(&h, 0)
+		}
+		return true
+	})
+	s.mu.Unlock()
+
+	sort.Slice(h, func(i, j int) bool {
+		if h[i].Size != h[j].Size {
+			return h[i].Size > h[j].Size
+		}
+		return h[i].Key < h[j].Key
+	})
+	writeJSON(w, http.StatusOK, map[string]interface{}{"keys": []keySize(h)})
+}
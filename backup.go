@@ -0,0 +1,264 @@
+package main
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Backup archive format, as produced by GET /api/admin/backup: a gzip stream
+// holding one JSON document
+//
+//	{
+//	  "format":   "web_server-backup",
+//	  "version":  2,
+//	  "created":  RFC 3339 time the backup was taken,
+//	  "data_version": store version at that time,
+//	  "counters": {"requests": ..., "expired_keys": ...},
+//	  "entries":  {"<key>": {"value": "...", "modified": time, "expires_at": time or omitted}},
+//	  "lists":    {"<key>": ["...", ...]}
+//	}
+//
+// Version 1 archives have no lists and restore as having none. Restore
+// rejects any other format name and any version it does not know.
+const (
+	backupFormat  = "web_server-backup"
+	backupVersion = 2
+)
+
+type backupArchive struct {
+	Format      string                 `json:"format"`
+	Version     int                    `json:"version"`
+	Created     time.Time              `json:"created"`
+	DataVersion uint64                 `json:"data_version"`
+	Counters    map[string]int         `json:"counters"`
+	Entries     map[string]backupEntry `json:"entries"`
+	Lists       map[string][]string    `json:"lists,omitempty"`
+}
+
+type backupEntry struct {
+	Value     string     `json:"value"`
+	Modified  time.Time  `json:"modified"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+func backupEntryOf(e entry) backupEntry {
+	be := backupEntry{Value: e.Value, Modified: e.Modified}
+	if !e.ExpiresAt.IsZero() {
+		exp := e.ExpiresAt
+		be.ExpiresAt = &exp
+	}
+	return be
+}
+
+func (be backupEntry) entry() entry {
+	e := entry{Value: be.Value, Modified: be.Modified}
+	if be.ExpiresAt != nil {
+		e.ExpiresAt = *be.ExpiresAt
+	}
+	return e
+}
+
+func (s *Server) backupHandler(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	s.lists.mu.Lock()
+	s.mu.Lock()
+	archive := backupArchive{
+		Format:      backupFormat,
+		Version:     backupVersion,
+		Created:     now.UTC(),
+		DataVersion: s.version,
+		Counters: map[string]int{
+			"requests":     s.requests,
+			"expired_keys": s.expiredKeys,
+		},
+		Entries: make(map[string]backupEntry, s.data.Len()),
+	}
+	s.data.Range(func(k string, e entry) bool {
+		if e.expired(now) {
+			return true
+		}
+		archive.Entries[k] = backupEntryOf(e)
+		return true
+	})
+	archive.Lists = s.copyListsLocked()
+	s.mu.Unlock()
+	s.lists.mu.Unlock()
+
+	name := fmt.Sprintf("backup-%s.json.gz", now.UTC().Format("20060102T150405Z"))
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", name))
+
+	gz := gzip.NewWriter(w)
+	if err := json.NewEncoder(gz).Encode(archive); err != nil {
+		logger.Println("Backup error:", err)
+		return
+	}
+	if err := gz.Close(); err != nil {
+		logger.Println("Backup error:", err)
+		return
+	}
+	logger.Printf("Backup of %d keys and %d lists sent to %s\n", len(archive.Entries), len(archive.Lists), r.RemoteAddr)
+}
+
+// restoreHandler replaces the whole dataset with the archive's entries and
+// lists. The archive is fully decoded and validated before the lock is
+// taken, so a bad upload leaves the current data untouched: keys and values
+// go through the same checks as writes, answering 422 like a rejected POST,
+// and the restored dataset must fit -max-bytes and the namespace quotas.
+// Restored entries all carry the restore's data version, invalidating lock
+// tokens issued before it. Sinks get a single "restore" event; the
+// replicator cannot apply one, so restores are refused with 409 while
+// -replica-url is set.
+func (s *Server) restoreHandler(w http.ResponseWriter, r *http.Request) {
+	if s.replica != nil {
+		writeError(w, http.StatusConflict, codeReplicating, "Restore is not replicated; stop replicating to -replica-url first")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, s.cfg.MaxRestoreBytes)
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		s.writeRestoreReadError(w, err, "Body is not a gzip backup archive")
+		return
+	}
+	defer gz.Close()
+
+	var archive backupArchive
+	if err := json.NewDecoder(gz).Decode(&archive); err != nil {
+		s.writeRestoreReadError(w, err, "Invalid backup archive: "+err.Error())
+		return
+	}
+	if archive.Format != backupFormat {
+		writeError(w, http.StatusBadRequest, codeInvalidBackup, fmt.Sprintf("Unknown backup format %q", archive.Format))
+		return
+	}
+	if archive.Version < 1 || archive.Version > backupVersion {
+		writeError(w, http.StatusBadRequest, codeInvalidBackup, fmt.Sprintf("Unsupported backup version %d (want 1 to %d)", archive.Version, backupVersion))
+		return
+	}
+	if verrs := s.validateArchive(&archive); len(verrs) > 0 {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":  localizedMessage(w, codeValidationFailed, "Validation failed"),
+			"code":   codeValidationFailed,
+			"errors": verrs,
+		})
+		return
+	}
+
+	now := time.Now()
+	entries := make(map[string]entry, len(archive.Entries))
+	lists := make(map[string][]string, len(archive.Lists))
+	total := 0
+	byNS := make(map[string]*namespaceGrowth)
+	grow := func(key string, bytes int) {
+		total += bytes
+		g := byNS[s.namespaceOf(key)]
+		if g == nil {
+			g = &namespaceGrowth{}
+			byNS[s.namespaceOf(key)] = g
+		}
+		g.keys++
+		g.bytes += bytes
+	}
+	for k, be := range archive.Entries {
+		if e := be.entry(); !e.expired(now) {
+			entries[k] = e
+			grow(k, len(k)+len(e.Value))
+		}
+	}
+	for k, list := range archive.Lists {
+		if len(list) > 0 {
+			lists[k] = list
+			grow(k, listBytes(k, list))
+		}
+	}
+
+	s.lists.mu.Lock()
+	s.lock()
+	// Capacity is checked as growth over the current dataset, so a restore
+	// that shrinks an over-full store is still accepted.
+	total -= s.dataBytes
+	for ns, g := range byNS {
+		if u := s.nsUsage[ns]; u != nil {
+			g.keys -= u.keys
+			g.bytes -= u.bytes
+		}
+	}
+	if err := s.checkGrowthLocked(total, byNS); err != nil {
+		s.mu.Unlock()
+		s.lists.mu.Unlock()
+		s.writeCapacityError(w, err)
+		return
+	}
+	s.version++
+	store := newStore()
+	for k, e := range entries {
+		e.Version = s.version
+		store.Set(k, e)
+	}
+	s.data = store
+	s.lists.lists = lists
+	s.recountLocked()
+	s.notifier.publish(changeEvent{Op: "restore", Time: now})
+	s.mu.Unlock()
+	s.lists.mu.Unlock()
+
+	logger.Printf("Restored %d keys and %d lists from backup taken %s\n", len(entries), len(lists), archive.Created.Format(time.RFC3339))
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "ok",
+		"keys":    len(entries),
+		"lists":   len(lists),
+		"created": archive.Created,
+	})
+}
+
+// validateArchive checks every key and value of an archive as a write would:
+// keys against validateKey, values against -max-value-bytes and lists
+// against -max-list-length. Problems are reported sorted by key.
+func (s *Server) validateArchive(archive *backupArchive) []validationError {
+	var verrs []validationError
+	check := func(key string, values ...string) {
+		if s.normalizeKey(key) != key {
+			verrs = append(verrs, validationError{Key: key, Error: "key is not lower case, as -case-insensitive-keys requires"})
+			return
+		}
+		if err := s.validateKey(key); err != nil {
+			verrs = append(verrs, validationError{Key: key, Error: err.Error()})
+			return
+		}
+		for _, v := range values {
+			if s.valueTooLarge(v) {
+				verrs = append(verrs, validationError{Key: key, Error: s.valueTooLargeMessage(v)})
+				return
+			}
+		}
+	}
+	for k, be := range archive.Entries {
+		check(k, be.Value)
+	}
+	for k, list := range archive.Lists {
+		if len(list) > s.cfg.MaxListLength {
+			verrs = append(verrs, validationError{Key: k, Error: fmt.Sprintf("list has %d values, more than the %d allowed", len(list), s.cfg.MaxListLength)})
+			continue
+		}
+		check(k, list...)
+	}
+	sort.Slice(verrs, func(i, j int) bool { return verrs[i].Key < verrs[j].Key })
+	return verrs
+}
+
+// writeRestoreReadError answers a failure reading the archive: 413 when it
+// went past -max-restore-bytes and 400 with msg otherwise.
+func (s *Server) writeRestoreReadError(w http.ResponseWriter, err error, msg string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, fmt.Sprintf("Backup archive is larger than %d bytes", s.cfg.MaxRestoreBytes))
+		return
+	}
+	writeError(w, http.StatusBadRequest, codeInvalidBackup, msg)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBackupIsNotGzippedTwice(t *testing.T) {
+	_, ts := newTestServer(t, "-gzip", "-admin-key", "secret")
+	expect(t, ts, http.MethodPost, "/api/data", `{"k":"v"}`, http.StatusOK)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/admin/backup", nil)
+	req.Header.Set("X-Admin-Key", "secret")
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if enc := resp.Header.Get("Content-Encoding"); enc != "" {
+		t.Fatalf("backup sent with Content-Encoding %q", enc)
+	}
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var archive backupArchive
+	decodeJSON(t, gz, &archive)
+	if archive.Entries["k"].Value != "v" {
+		t.Fatalf("archive entries %+v, want k=v", archive.Entries)
+	}
+}
+
+// restoreArchive posts entries as a backup archive and returns the status
+// and body of the answer.
+func restoreArchive(t *testing.T, ts *httptest.Server, entries map[string]string) (int, string) {
+	t.Helper()
+	archive := backupArchive{Format: backupFormat, Version: backupVersion, Entries: map[string]backupEntry{}}
+	for k, v := range entries {
+		archive.Entries[k] = backupEntry{Value: v}
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	json.NewEncoder(gz).Encode(archive)
+	gz.Close()
+
+	req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/admin/restore", &buf)
+	req.Header.Set("X-Admin-Key", "secret")
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(b)
+}
+
+func TestRestoreValidatesEntries(t *testing.T) {
+	s, ts := newTestServer(t, "-admin-key", "secret", "-max-key-length", "8",
+		"-namespace-separator", ":", "-namespace-quotas", "app=1/0")
+	expect(t, ts, http.MethodPost, "/api/data", `{"kept":"1"}`, http.StatusOK)
+
+	for name, entries := range map[string]map[string]string{
+		"reserved word": {"diff": "x"},
+		"long key":      {"much-too-long": "x"},
+	} {
+		status, body := restoreArchive(t, ts, entries)
+		if status != http.StatusUnprocessableEntity || !strings.Contains(body, codeValidationFailed) {
+			t.Errorf("%s: status %d body %s, want 422 %s", name, status, body, codeValidationFailed)
+		}
+	}
+	if status, body := restoreArchive(t, ts, map[string]string{"app:a": "1", "app:b": "2"}); status != http.StatusInsufficientStorage {
+		t.Errorf("restore over a namespace quota: status %d body %s, want 507", status, body)
+	}
+	if got := getValue(t, ts, "kept"); got != "1" {
+		t.Fatalf("rejected restore changed the data: kept = %q", got)
+	}
+
+	if status, body := restoreArchive(t, ts, map[string]string{"app:a": "1", "b": "2"}); status != http.StatusOK {
+		t.Fatalf("valid restore: status %d body %s", status, body)
+	}
+	expect(t, ts, http.MethodGet, "/api/data/kept", "", http.StatusNotFound)
+	if s.dataBytes != len("app:a1")+len("b2") {
+		t.Fatalf("db_bytes %d after restore, want %d", s.dataBytes, len("app:a1")+len("b2"))
+	}
+}
+
+func TestRestoreBodyLimit(t *testing.T) {
+	_, ts := newTestServer(t, "-admin-key", "secret", "-max-restore-bytes", "256")
+	big := make(map[string]string)
+	for i := 0; i < 200; i++ {
+		big[fmt.Sprintf("k%d", i)] = fmt.Sprintf("%x", i*7919)
+	}
+	if status, body := restoreArchive(t, ts, big); status != http.StatusRequestEntityTooLarge {
+		t.Fatalf("oversized restore: status %d body %s, want 413", status, body)
+	}
+	if status, body := restoreArchive(t, ts, map[string]string{"k": "v"}); status != http.StatusOK {
+		t.Fatalf("small restore: status %d body %s, want 200", status, body)
+	}
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pendingUpload is a chunked upload assembled in memory until it is
+// committed to the store.
+type pendingUpload struct {
+	buf     bytes.Buffer
+	next    int
+	updated time.Time
+}
+
+type uploadTable struct {
+	mu      sync.Mutex
+	pending map[string]*pendingUpload
+}
+
+// expectedLocked returns the chunk number the upload for key expects next,
+// 0 when none is pending. The caller must hold t.mu.
+func (t *uploadTable) expectedLocked(key string) int {
+	if up := t.pending[key]; up != nil {
+		return up.next
+	}
+	return 0
+}
+
+// putBlobChunkHandler appends the request body to the pending upload for
+// {key}. Chunks must arrive in order starting at ?chunk=0; sending chunk 0
+// again restarts the upload. The body is read without holding uploads.mu, so
+// a slow client only holds up its own upload, and the chunk is appended only
+// if no other chunk for the upload was accepted meanwhile.
+func (s *Server) putBlobChunkHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := s.validateKey(key); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidKey, err.Error())
+		return
+	}
+	chunk, err := strconv.Atoi(r.URL.Query().Get("chunk"))
+	if err != nil || chunk < 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, "chunk must be a non-negative integer")
+		return
+	}
+
+	s.uploads.mu.Lock()
+	up := s.uploads.pending[key]
+	if chunk == 0 {
+		if up == nil && s.cfg.MaxUploads > 0 && len(s.uploads.pending) >= s.cfg.MaxUploads {
+			s.uploads.mu.Unlock()
+			w.Header().Set("Retry-After", "5")
+			writeError(w, http.StatusServiceUnavailable, codeTooManyUploads, "Too many uploads in progress")
+			return
+		}
+		up = &pendingUpload{updated: time.Now()}
+		s.uploads.pending[key] = up
+	}
+	if up == nil || chunk != up.next {
+		expected := s.uploads.expectedLocked(key)
+		s.uploads.mu.Unlock()
+		writeError(w, http.StatusConflict, codeChunkOutOfOrder, fmt.Sprintf("Expected chunk %d", expected))
+		return
+	}
+	limit := s.cfg.BlobMaxBytes - int64(up.buf.Len())
+	s.uploads.mu.Unlock()
+
+	var buf bytes.Buffer
+	n, err := io.Copy(&buf, io.LimitReader(r.Body, limit+1))
+
+	s.uploads.mu.Lock()
+	defer s.uploads.mu.Unlock()
+	if s.uploads.pending[key] != up || up.next != chunk {
+		// The upload was restarted, dropped or given this chunk by another
+		// request while the body was being read.
+		writeError(w, http.StatusConflict, codeChunkOutOfOrder, fmt.Sprintf("Expected chunk %d", s.uploads.expectedLocked(key)))
+		return
+	}
+	if err != nil {
+		delete(s.uploads.pending, key)
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, "Failed to read chunk")
+		return
+	}
+	if n > limit {
+		delete(s.uploads.pending, key)
+		writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, fmt.Sprintf("Upload exceeds %d bytes", s.cfg.BlobMaxBytes))
+		return
+	}
+	up.buf.Write(buf.Bytes())
+	up.next++
+	up.updated = time.Now()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "next_chunk": up.next, "size": up.buf.Len()})
+}
+
+// commitBlobHandler stores a completed chunked upload as the value of {key}.
+func (s *Server) commitBlobHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+	expiresAt, err := s.expiryFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+		return
+	}
+
+	s.uploads.mu.Lock()
+	up := s.uploads.pending[key]
+	delete(s.uploads.pending, key)
+	s.uploads.mu.Unlock()
+
+	if up == nil {
+		writeError(w, http.StatusNotFound, codeKeyNotFound, "No upload in progress for key")
+		return
+	}
+	value := up.buf.String()
+
+	s.lock()
+	s.incRequests(key)
+	if err := s.checkCapacityLocked(map[string]string{key: value}); err != nil {
+		s.mu.Unlock()
+		s.writeCapacityError(w, err)
+		return
+	}
+	s.setLocked(key, value, expiresAt)
+	s.notifier.publish(changeEvent{Op: "set", Key: key, Value: value, Time: time.Now()})
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "size": len(value)})
+}
+
+// getBlobHandler serves a value as raw bytes, honoring Range so downloads can
+// be split into chunks and resumed.
+func (s *Server) getBlobHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	s.lock()
+	s.incRequests(key)
+	e, found := s.data.Get(key)
+	s.mu.Unlock()
+
+	if !found || e.expired(now) {
+		writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	http.ServeContent(w, r, "", e.Modified, strings.NewReader(e.Value))
+}
+
+// sweepUploads drops chunked uploads that have not received a chunk within
+// -upload-timeout.
+func (s *Server) sweepUploads() int {
+	cutoff := time.Now().Add(-s.cfg.UploadTimeout)
+
+	s.uploads.mu.Lock()
+	defer s.uploads.mu.Unlock()
+
+	n := 0
+	for key, up := range s.uploads.pending {
+		if up.updated.Before(cutoff) {
+			delete(s.uploads.pending, key)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestStalledChunkDoesNotBlockUploads(t *testing.T) {
+	s, ts := newTestServer(t)
+
+	pr, pw := io.Pipe()
+	stalled := make(chan int, 1)
+	go func() {
+		req, _ := http.NewRequest(http.MethodPost, ts.URL+"/api/blob/slow?chunk=0", pr)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			stalled <- 0
+			return
+		}
+		resp.Body.Close()
+		stalled <- resp.StatusCode
+	}()
+	pw.Write([]byte("partial"))
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		expect(t, ts, http.MethodPost, "/api/blob/fast?chunk=0", "abc", http.StatusOK)
+		expect(t, ts, http.MethodPost, "/api/blob/fast/commit", "", http.StatusOK)
+		s.sweepUploads()
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("a stalled chunk blocked other uploads")
+	}
+	if got := getValue(t, ts, "fast"); got != "abc" {
+		t.Fatalf("fast = %q, want abc", got)
+	}
+
+	pw.Write([]byte(" rest"))
+	pw.Close()
+	if status := <-stalled; status != http.StatusOK {
+		t.Fatalf("stalled chunk finished with %d, want 200", status)
+	}
+	expect(t, ts, http.MethodPost, "/api/blob/slow/commit", "", http.StatusOK)
+	if got := getValue(t, ts, "slow"); got != "partial rest" {
+		t.Fatalf("slow = %q, want %q", got, "partial rest")
+	}
+}
+
+func TestMaxUploads(t *testing.T) {
+	_, ts := newTestServer(t, "-max-uploads", "1")
+	expect(t, ts, http.MethodPost, "/api/blob/a?chunk=0", "x", http.StatusOK)
+	expect(t, ts, http.MethodPost, "/api/blob/b?chunk=0", "x", http.StatusServiceUnavailable)
+	// Restarting a pending upload does not need another slot.
+	expect(t, ts, http.MethodPost, "/api/blob/a?chunk=0", "y", http.StatusOK)
+	expect(t, ts, http.MethodPost, "/api/blob/a/commit", "", http.StatusOK)
+	expect(t, ts, http.MethodPost, "/api/blob/b?chunk=0", "x", http.StatusOK)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+)
+
+// bufferResponses holds each response in memory until the handler returns
+// so it can be sent with a Content-Length. A response growing past limit
+// bytes, or one the handler flushes, is streamed from then on instead, and
+// one declaring trailers never gets a Content-Length, since trailers need a
+// chunked body.
+func bufferResponses(limit int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bw := &bufferedWriter{ResponseWriter: w, limit: limit}
+		next.ServeHTTP(bw, r)
+		bw.finish()
+	})
+}
+
+type bufferedWriter struct {
+	http.ResponseWriter
+	limit     int
+	status    int
+	buf       bytes.Buffer
+	streaming bool
+}
+
+func (b *bufferedWriter) WriteHeader(status int) {
+	if b.status == 0 {
+		b.status = status
+	}
+}
+
+func (b *bufferedWriter) Write(p []byte) (int, error) {
+	if b.status == 0 {
+		b.status = http.StatusOK
+	}
+	if !b.streaming && b.buf.Len()+len(p) > b.limit {
+		b.startStreaming()
+	}
+	if b.streaming {
+		return b.ResponseWriter.Write(p)
+	}
+	return b.buf.Write(p)
+}
+
+func (b *bufferedWriter) Flush() {
+	if !b.streaming {
+		if b.status == 0 {
+			b.status = http.StatusOK
+		}
+		b.startStreaming()
+	}
+	if f, ok := b.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (b *bufferedWriter) startStreaming() {
+	b.streaming = true
+	b.ResponseWriter.WriteHeader(b.status)
+	if b.buf.Len() > 0 {
+		b.ResponseWriter.Write(b.buf.Bytes())
+		b.buf.Reset()
+	}
+}
+
+func (b *bufferedWriter) finish() {
+	if b.streaming || b.status == 0 {
+		return
+	}
+	h := b.Header()
+	if h.Get("Content-Length") == "" && h.Get("Trailer") == "" && b.status != http.StatusNoContent && b.status != http.StatusNotModified {
+		h.Set("Content-Length", strconv.Itoa(b.buf.Len()))
+	}
+	b.ResponseWriter.WriteHeader(b.status)
+	b.ResponseWriter.Write(b.buf.Bytes())
+}
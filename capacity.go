@@ -0,0 +1,140 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+var errStoreFull = errors.New("store full")
+
+// quotaExceededError names the namespace a write would have grown past its
+// -namespace-quotas entry.
+type quotaExceededError struct {
+	namespace string
+	what      string
+	limit     int
+}
+
+func (e *quotaExceededError) Error() string {
+	return fmt.Sprintf("Write would exceed the %d %s quota of namespace %q", e.limit, e.what, e.namespace)
+}
+
+// sizeDeltaLocked returns how much writing value to key would change
+// dataBytes. The caller must hold s.mu.
+func (s *Server) sizeDeltaLocked(key, value string) int {
+	delta := len(key) + len(value)
+	if old, ok := s.data.Get(key); ok {
+		delta -= len(key) + len(old.Value)
+	}
+	return delta
+}
+
+// fitsLocked reports whether growing the store by delta bytes keeps it within
+// -max-bytes. Writes that do not grow the store always fit, so clients can
+// still shrink or overwrite values when the store is full. The caller must
+// hold s.mu.
+func (s *Server) fitsLocked(delta int) bool {
+	return s.cfg.MaxBytes <= 0 || delta <= 0 || s.dataBytes+delta <= s.cfg.MaxBytes
+}
+
+// namespaceGrowth is how many keys and bytes a write adds to one namespace.
+type namespaceGrowth struct{ keys, bytes int }
+
+// checkCapacityLocked reports whether writing values (key to new value) fits
+// both -max-bytes and the quota of every namespace it touches, returning
+// errStoreFull or a *quotaExceededError when it does not. As with -max-bytes,
+// only growth is refused. The caller must hold s.mu.
+func (s *Server) checkCapacityLocked(values map[string]string) error {
+	total := 0
+	byNS := make(map[string]*namespaceGrowth)
+	for k, v := range values {
+		delta := s.sizeDeltaLocked(k, v)
+		total += delta
+		ns := s.namespaceOf(k)
+		g, ok := byNS[ns]
+		if !ok {
+			g = &namespaceGrowth{}
+			byNS[ns] = g
+		}
+		g.bytes += delta
+		if _, exists := s.data.Get(k); !exists {
+			g.keys++
+		}
+	}
+	return s.checkGrowthLocked(total, byNS)
+}
+
+// checkListPushLocked is checkCapacityLocked for appending values to the
+// list at key. The caller must hold s.lists.mu and s.mu.
+func (s *Server) checkListPushLocked(key string, values []string) error {
+	g := &namespaceGrowth{bytes: listBytes("", values)}
+	if _, exists := s.lists.lists[key]; !exists {
+		g.keys, g.bytes = 1, g.bytes+len(key)
+	}
+	return s.checkGrowthLocked(g.bytes, map[string]*namespaceGrowth{s.namespaceOf(key): g})
+}
+
+func (s *Server) checkGrowthLocked(total int, byNS map[string]*namespaceGrowth) error {
+	if !s.fitsLocked(total) {
+		return errStoreFull
+	}
+
+	namespaces := make([]string, 0, len(byNS))
+	for ns := range byNS {
+		namespaces = append(namespaces, ns)
+	}
+	sort.Strings(namespaces)
+	for _, ns := range namespaces {
+		q, ok := s.quotaFor(ns)
+		if !ok {
+			continue
+		}
+		g := byNS[ns]
+		var used namespaceUsage
+		if u := s.nsUsage[ns]; u != nil {
+			used = *u
+		}
+		var err *quotaExceededError
+		switch {
+		case q.MaxKeys > 0 && g.keys > 0 && used.keys+g.keys > q.MaxKeys:
+			err = &quotaExceededError{namespace: ns, what: "key", limit: q.MaxKeys}
+		case q.MaxBytes > 0 && g.bytes > 0 && used.bytes+g.bytes > q.MaxBytes:
+			err = &quotaExceededError{namespace: ns, what: "byte", limit: q.MaxBytes}
+		default:
+			continue
+		}
+		s.usageLocked(ns).rejected++
+		return err
+	}
+	return nil
+}
+
+// valueTooLarge reports whether value, in its stored form, is longer than
+// -max-value-bytes.
+func (s *Server) valueTooLarge(value string) bool {
+	return s.cfg.MaxValueBytes > 0 && len(value) > s.cfg.MaxValueBytes
+}
+
+func (s *Server) valueTooLargeMessage(value string) string {
+	return fmt.Sprintf("value is %d bytes, longer than the %d byte limit", len(value), s.cfg.MaxValueBytes)
+}
+
+type valueTooLargeError struct {
+	msg string
+}
+
+func (e *valueTooLargeError) Error() string {
+	return e.msg
+}
+
+// writeCapacityError answers a checkCapacityLocked error with 507.
+func (s *Server) writeCapacityError(w http.ResponseWriter, err error) {
+	var quota *quotaExceededError
+	if errors.As(err, &quota) {
+		writeError(w, http.StatusInsufficientStorage, codeQuotaExceeded, quota.Error())
+		return
+	}
+	writeError(w, http.StatusInsufficientStorage, codeStoreFull, fmt.Sprintf("Write would exceed the %d byte store limit", s.cfg.MaxBytes))
+}
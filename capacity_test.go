@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestLargeNestedValueTooLarge(t *testing.T) {
+	// 200 levels of nesting around 1000 numbers: far beyond the limit once
+	// stored, though each element is tiny.
+	inner := strings.TrimSuffix(strings.Repeat("1,", 1000), ",")
+	value := strings.Repeat("[", 200) + inner + strings.Repeat("]", 200)
+
+	_, ts := newTestServer(t, "-max-value-bytes", "1024")
+	for _, req := range []struct{ method, path, body, code string }{
+		{http.MethodPost, "/api/data", fmt.Sprintf(`{"big":%s}`, value), codeValidationFailed},
+		{http.MethodPut, "/api/data/big", fmt.Sprintf(`{"value":%s}`, value), codeValueTooLarge},
+		{http.MethodPost, "/api/list/big/push", fmt.Sprintf(`{"value":%s}`, value), codeValueTooLarge},
+	} {
+		resp, body := do(t, ts, req.method, req.path, req.body)
+		var got apiError
+		decodeBody(t, body, &got)
+		if resp.StatusCode != http.StatusRequestEntityTooLarge || got.Code != req.code {
+			t.Errorf("%s %s: %d %q, want 413 %q", req.method, req.path, resp.StatusCode, got.Code, req.code)
+		}
+	}
+	expect(t, ts, http.MethodGet, "/api/data/big", "", http.StatusNotFound)
+	expect(t, ts, http.MethodGet, "/api/list/big", "", http.StatusNotFound)
+
+	// Under the limit an array is still refused for its type.
+	small := strings.Repeat("[", 10) + "1" + strings.Repeat("]", 10)
+	expect(t, ts, http.MethodPut, "/api/data/small", fmt.Sprintf(`{"value":%s}`, small), http.StatusBadRequest)
+}
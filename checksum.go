@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// checksumMismatchError is returned by verifyBodyChecksum when the body does
+// not match a digest header the client sent.
+type checksumMismatchError struct {
+	header string
+}
+
+func (e *checksumMismatchError) Error() string {
+	return fmt.Sprintf("body does not match %s", e.header)
+}
+
+// verifyBodyChecksum checks the request body against Content-MD5 (base64)
+// and X-Checksum-SHA256 (hex), whichever are present. The digests are
+// computed while the body is read into memory, and r.Body is replaced with
+// that copy so the handler can decode it afterwards. Without either header
+// the body is left alone.
+func verifyBodyChecksum(r *http.Request) error {
+	wantMD5 := r.Header.Get("Content-MD5")
+	wantSHA := r.Header.Get("X-Checksum-SHA256")
+	if wantMD5 == "" && wantSHA == "" {
+		return nil
+	}
+
+	md5h, shah := md5.New(), sha256.New()
+	var buf bytes.Buffer
+	if _, err := io.Copy(&buf, io.TeeReader(r.Body, io.MultiWriter(md5h, shah))); err != nil {
+		return err
+	}
+	r.Body = io.NopCloser(&buf)
+
+	if wantMD5 != "" && !digestMatches(md5h, wantMD5, base64.StdEncoding.EncodeToString) {
+		return &checksumMismatchError{header: "Content-MD5"}
+	}
+	if wantSHA != "" && !digestMatches(shah, strings.ToLower(wantSHA), hex.EncodeToString) {
+		return &checksumMismatchError{header: "X-Checksum-SHA256"}
+	}
+	return nil
+}
+
+func digestMatches(h hash.Hash, want string, encode func([]byte) string) bool {
+	return encode(h.Sum(nil)) == strings.TrimSpace(want)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// writeCoalescer merges PUTs to the same key that arrive within
+// -coalesce-window of the first one. Only the last value is written, under a
+// single lock acquisition, and every merged request is answered once that
+// write has landed, so no acknowledged write is lost. A PUT only waits out
+// the window when another write to the key is already in flight; an
+// uncontended one is written at once. The cost is staleness: under
+// contention readers keep seeing the previous value for up to the window
+// after a PUT was sent, and intermediate values are never stored or
+// published.
+type writeCoalescer struct {
+	mu      sync.Mutex
+	pending map[string]*coalescedWrite
+	// active counts the writes in flight per key, dropping keys at zero.
+	active map[string]int
+}
+
+type coalescedWrite struct {
+	value     string
+	expiresAt time.Time
+	requests  int
+	done      chan struct{}
+	modified  time.Time
+	err       error
+}
+
+// coalescedSet joins or starts the pending write for key and returns the
+// modification time of the write that finally covered it. It returns the
+// checkCapacityLocked error, for every merged request, when the final value
+// did not fit.
+func (s *Server) coalescedSet(key, value string, expiresAt time.Time) (time.Time, error) {
+	c := &s.coalescer
+	c.mu.Lock()
+	if cw, ok := c.pending[key]; ok {
+		cw.value, cw.expiresAt = value, expiresAt
+		cw.requests++
+		c.mu.Unlock()
+		s.coalescedWrites.Add(1)
+		<-cw.done
+		return cw.modified, cw.err
+	}
+	cw := &coalescedWrite{value: value, expiresAt: expiresAt, requests: 1, done: make(chan struct{})}
+	c.pending[key] = cw
+	contended := c.active[key] > 0
+	c.active[key]++
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		if c.active[key]--; c.active[key] == 0 {
+			delete(c.active, key)
+		}
+		c.mu.Unlock()
+	}()
+
+	if contended {
+		time.Sleep(s.cfg.CoalesceWindow)
+	}
+
+	c.mu.Lock()
+	delete(c.pending, key)
+	value, expiresAt = cw.value, cw.expiresAt
+	c.mu.Unlock()
+
+	s.lock()
+	for i := 0; i < cw.requests; i++ {
+		s.incRequests(key)
+	}
+	if cw.err = s.checkCapacityLocked(map[string]string{key: value}); cw.err != nil {
+		s.mu.Unlock()
+		close(cw.done)
+		return time.Time{}, cw.err
+	}
+	s.setLocked(key, value, expiresAt)
+	e, _ := s.data.Get(key)
+	cw.modified = e.Modified
+	s.notifier.publish(changeEvent{Op: "set", Key: key, Value: value, Time: cw.modified})
+	s.mu.Unlock()
+	close(cw.done)
+	return cw.modified, nil
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestUncontendedPutSkipsCoalesceWindow(t *testing.T) {
+	_, ts := newTestServer(t, "-coalesce-window", "2s")
+	start := time.Now()
+	expect(t, ts, http.MethodPut, "/api/data/k", `{"value":"v"}`, http.StatusOK)
+	if d := time.Since(start); d > time.Second {
+		t.Fatalf("uncontended PUT took %s, want no coalescing wait", d)
+	}
+	if got := getValue(t, ts, "k"); got != "v" {
+		t.Fatalf("k = %q, want v", got)
+	}
+}
+
+// BenchmarkHotKeyWrites has every goroutine PUT the same key, with
+// coalescing off and on.
+func BenchmarkHotKeyWrites(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"coalesce-off", nil},
+		{"coalesce-on", []string{"-coalesce-window", "1ms"}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			s, _ := newTestServer(b, tc.args...)
+			h := s.routes()
+			var n atomic.Int64
+
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					body := fmt.Sprintf(`{"value":"%d"}`, n.Add(1))
+					req := httptest.NewRequest(http.MethodPut, "/api/data/hot", strings.NewReader(body))
+					req.Header.Set("Content-Type", "application/json")
+					w := httptest.NewRecorder()
+					h.ServeHTTP(w, req)
+					if w.Code != http.StatusOK {
+						b.Fatalf("status %d", w.Code)
+					}
+				}
+			})
+			b.StopTimer()
+			b.ReportMetric(float64(s.coalescedWrites.Load())/float64(b.N), "merged/op")
+		})
+	}
+}
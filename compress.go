@@ -0,0 +1,146 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// acceptsGzip reports whether an Accept-Encoding header value allows a gzip
+// response. An explicit gzip entry wins over a wildcard, and a q-value of 0
+// means the coding is not acceptable.
+func acceptsGzip(header string) bool {
+	gzipQ, starQ := -1.0, -1.0
+	for _, part := range strings.Split(header, ",") {
+		coding, q := parseCoding(part)
+		switch coding {
+		case "gzip", "x-gzip":
+			gzipQ = q
+		case "*":
+			starQ = q
+		}
+	}
+	if gzipQ >= 0 {
+		return gzipQ > 0
+	}
+	return starQ > 0
+}
+
+func parseCoding(part string) (string, float64) {
+	fields := strings.Split(part, ";")
+	coding := strings.ToLower(strings.TrimSpace(fields[0]))
+	q := 1.0
+	for _, param := range fields[1:] {
+		name, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+		if !ok || strings.ToLower(strings.TrimSpace(name)) != "q" {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil || v < 0 || v > 1 {
+			v = 0
+		}
+		q = v
+	}
+	return coding, q
+}
+
+// parseGzipLevel accepts a -gzip-level value: a number from 1 to 9 or one of
+// the names of the compress/gzip level constants.
+func parseGzipLevel(v string) (int, error) {
+	switch v {
+	case "default":
+		return gzip.DefaultCompression, nil
+	case "best-speed":
+		return gzip.BestSpeed, nil
+	case "best-compression":
+		return gzip.BestCompression, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < gzip.BestSpeed || n > gzip.BestCompression {
+		return 0, fmt.Errorf(`invalid -gzip-level %q: want 1 to 9, "default", "best-speed" or "best-compression"`, v)
+	}
+	return n, nil
+}
+
+func gzipMiddleware(level int, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if r.Method == http.MethodHead || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{ResponseWriter: w, level: level}
+		defer gw.Close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// alreadyCompressed reports whether a Content-Type is a compressed format
+// that gzip would only grow, such as a backup archive.
+func alreadyCompressed(contentType string) bool {
+	mt, _, _ := strings.Cut(contentType, ";")
+	switch strings.TrimSpace(strings.ToLower(mt)) {
+	case "application/gzip", "application/x-gzip", "application/zip", "application/zstd":
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter decides whether to compress when the status is known,
+// leaving empty, partial and already encoded or compressed responses
+// untouched.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	level       int
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(status int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+
+	h := g.Header()
+	compressible := status != http.StatusNoContent &&
+		status != http.StatusNotModified &&
+		status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" &&
+		!alreadyCompressed(h.Get("Content-Type"))
+	if compressible {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		g.gz, _ = gzip.NewWriterLevel(g.ResponseWriter, g.level)
+	}
+	g.ResponseWriter.WriteHeader(status)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.gz == nil {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.gz.Write(b)
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if g.gz != nil {
+		g.gz.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Close() error {
+	if g.gz == nil {
+		return nil
+	}
+	return g.gz.Close()
+}
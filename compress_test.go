@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestAcceptsGzip(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		want   bool
+	}{
+		{"", false},
+		{"gzip", true},
+		{"GZIP", true},
+		{"x-gzip", true},
+		{"gzip;q=0", false},
+		{"gzip; q=0.0", false},
+		{"gzip;q=0.5", true},
+		{"identity", false},
+		{"identity, deflate", false},
+		{"*", true},
+		{"*;q=0", false},
+		{"gzip;q=0, *", false},
+		{"*;q=0, gzip", true},
+		{"gzip;q=bogus", false},
+	} {
+		if got := acceptsGzip(tc.header); got != tc.want {
+			t.Errorf("acceptsGzip(%q) = %v, want %v", tc.header, got, tc.want)
+		}
+	}
+}
+
+func TestGzipMiddlewareHonoursAcceptEncoding(t *testing.T) {
+	_, ts := newTestServer(t, "-gzip")
+	expect(t, ts, http.MethodPost, "/api/data", `{"k":"v"}`, http.StatusOK)
+
+	// DisableCompression keeps the transport from adding its own gzip
+	// request and decoding the response behind our back.
+	client := &http.Client{Transport: &http.Transport{DisableCompression: true}}
+	for header, want := range map[string]string{
+		"gzip;q=0": "",
+		"identity": "",
+		"*":        "gzip",
+		"gzip":     "gzip",
+	} {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/data/k", nil)
+		req.Header.Set("Accept-Encoding", header)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Content-Encoding"); got != want {
+			t.Errorf("Accept-Encoding %q: Content-Encoding %q, want %q", header, got, want)
+		}
+	}
+}
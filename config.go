@@ -0,0 +1,410 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Config holds the effective settings. Fields tagged redact:"true" are
+// masked when the config is exposed through /api/admin/config, and URLs in
+// fields tagged redact:"url" lose their user:pass@ credentials.
+type Config struct {
+	Addr                string                    `json:"addr"`
+	AdminKey            string                    `json:"admin_key" redact:"true"`
+	SnapshotPath        string                    `json:"snapshot_path"`
+	SnapshotInterval    time.Duration             `json:"snapshot_interval"`
+	SnapshotMinInterval time.Duration             `json:"snapshot_min_interval"`
+	SnapshotTimeout     time.Duration             `json:"snapshot_timeout"`
+	WorkerPanicMode     string                    `json:"worker_panic_mode"`
+	MaxKeyLength        int                       `json:"max_key_length"`
+	UseNumber           bool                      `json:"use_number"`
+	MaxFullGetKeys      int                       `json:"max_full_get_keys"`
+	Gzip                bool                      `json:"gzip"`
+	GzipLevel           int                       `json:"gzip_level"`
+	ReadOnly            bool                      `json:"read_only"`
+	DefaultTTL          time.Duration             `json:"default_ttl"`
+	LockMetrics         bool                      `json:"lock_metrics"`
+	StaticMaxAge        time.Duration             `json:"static_max_age"`
+	KeyPattern          string                    `json:"key_pattern"`
+	KeyRegexp           *regexp.Regexp            `json:"-"`
+	StaticDir           string                    `json:"static_dir"`
+	IndexFile           string                    `json:"index_file"`
+	NotifyWorkers       int                       `json:"notify_workers"`
+	NotifyQueue         int                       `json:"notify_queue"`
+	NotifyDrop          string                    `json:"notify_drop"`
+	VerboseStats        bool                      `json:"verbose_stats"`
+	MaxValueBytes       int                       `json:"max_value_bytes"`
+	BlobMaxBytes        int64                     `json:"blob_max_bytes"`
+	MaxUploads          int                       `json:"max_uploads"`
+	MaxRestoreBytes     int64                     `json:"max_restore_bytes"`
+	UploadTimeout       time.Duration             `json:"upload_timeout"`
+	CaseInsensitiveKeys bool                      `json:"case_insensitive_keys"`
+	AccessLog           bool                      `json:"access_log"`
+	LogDisconnects      bool                      `json:"log_disconnects"`
+	PersistRetries      int                       `json:"persist_retries"`
+	PersistBackoff      time.Duration             `json:"persist_backoff"`
+	AllowedMethods      []string                  `json:"allowed_methods"`
+	IdleTimeout         time.Duration             `json:"idle_timeout"`
+	KeepAlives          bool                      `json:"keep_alives"`
+	Transforms          []string                  `json:"transforms"`
+	MaxConnsPerIP       int                       `json:"max_conns_per_ip"`
+	MaxConcurrent       int                       `json:"max_concurrent"`
+	NamespaceSeparator  string                    `json:"namespace_separator"`
+	NamespaceQuotas     map[string]NamespaceQuota `json:"namespace_quotas"`
+	FaviconPath         string                    `json:"favicon_path"`
+	FaviconFile         string                    `json:"favicon_file"`
+	RobotsPath          string                    `json:"robots_path"`
+	RobotsFile          string                    `json:"robots_file"`
+	CoalesceWindow      time.Duration             `json:"coalesce_window"`
+	CORSOrigins         []string                  `json:"cors_origins"`
+	CORSMaxAge          time.Duration             `json:"cors_max_age"`
+	ShutdownTimeout     time.Duration             `json:"shutdown_timeout"`
+	MaxSubscribers      int                       `json:"max_subscribers"`
+	SubscriberBuffer    int                       `json:"subscriber_buffer"`
+	APIOnly             bool                      `json:"api_only"`
+	BufferResponses     int                       `json:"buffer_responses"`
+	MaxListLength       int                       `json:"max_list_length"`
+	KeysWarn            int                       `json:"keys_warn"`
+	KeysCritical        int                       `json:"keys_critical"`
+	RateLimits          []RateLimit               `json:"rate_limits"`
+	IdleLogAfter        time.Duration             `json:"idle_log_after"`
+	WorkerInterval      time.Duration             `json:"worker_interval"`
+	MaxBytes            int                       `json:"max_bytes"`
+	Pretty              bool                      `json:"pretty"`
+	MessagesDir         string                    `json:"messages_dir"`
+	Webhooks            []string                  `json:"webhooks" redact:"url"`
+	WebhookSecret       string                    `json:"webhook_secret" redact:"true"`
+	WebhookQueue        int                       `json:"webhook_queue"`
+	ReplicaURL          string                    `json:"replica_url" redact:"url"`
+	ReplicaQueue        int                       `json:"replica_queue"`
+	LogOutput           string                    `json:"log_output"`
+	LogMaxSize          int64                     `json:"log_max_size"`
+	LogMaxFiles         int                       `json:"log_max_files"`
+	SeedFile            string                    `json:"seed_file"`
+	PullFrom            string                    `json:"pull_from" redact:"url"`
+	PullRequired        bool                      `json:"pull_required"`
+	ContentTypes        []string                  `json:"content_types"`
+	MethodOverrides     []string                  `json:"method_overrides"`
+	BreakerFailures     int                       `json:"breaker_failures"`
+	BreakerCooldown     time.Duration             `json:"breaker_cooldown"`
+	ReadTimeout         time.Duration             `json:"read_timeout"`
+}
+
+func loadConfig() (*Config, error) {
+	return parseConfig(flag.CommandLine, os.Args[1:])
+}
+
+// parseConfig defines every flag on fs and parses args into a Config.
+func parseConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := &Config{}
+
+	fs.StringVar(&cfg.Addr, "addr", ":8080", "listen address")
+	fs.StringVar(&cfg.AdminKey, "admin-key", "", "key required in X-Admin-Key for /api/admin/* (empty disables the admin API)")
+	fs.StringVar(&cfg.SnapshotPath, "snapshot-path", "", "file to snapshot data to (empty disables snapshots)")
+	fs.DurationVar(&cfg.SnapshotInterval, "snapshot-interval", time.Minute, "interval between periodic snapshots (0 disables)")
+	fs.DurationVar(&cfg.SnapshotMinInterval, "snapshot-min-interval", 10*time.Second, "minimum time between two snapshots")
+	fs.DurationVar(&cfg.SnapshotTimeout, "snapshot-timeout", 30*time.Second, "abort a snapshot that takes longer than this (0 waits forever)")
+	fs.StringVar(&cfg.WorkerPanicMode, "worker-panic", "restart", `what to do when the background worker panics: "restart" or "crash"`)
+	// Keys are held in memory and echoed in URLs, so a modest bound keeps
+	// per-key overhead and request lines small; 256 bytes fits any sane
+	// namespaced key.
+	fs.IntVar(&cfg.MaxKeyLength, "max-key-length", 256, "maximum key length in bytes; longer path keys get 414, longer payload keys 422")
+	fs.BoolVar(&cfg.UseNumber, "use-number", false, "keep numeric values exactly as sent; without it they are stored as float64 prints them and numbers float64 cannot hold exactly are refused")
+	fs.IntVar(&cfg.MaxFullGetKeys, "max-full-get-keys", 100000, "largest store GET /api/data returns without pagination")
+	fs.BoolVar(&cfg.Gzip, "gzip", false, "gzip responses for clients that accept it")
+	fs.BoolVar(&cfg.ReadOnly, "read-only", false, "serve reads only; mutating endpoints answer 405")
+	fs.DurationVar(&cfg.DefaultTTL, "default-ttl", 0, "expiry for keys written without ?ttl= (0 means never expire; ?ttl=-1 overrides it per write)")
+	fs.BoolVar(&cfg.LockMetrics, "lock-metrics", true, "measure time handlers spend waiting for the data lock")
+	fs.DurationVar(&cfg.StaticMaxAge, "static-max-age", time.Hour, "Cache-Control max-age for /public/ assets (0 forces revalidation)")
+	fs.StringVar(&cfg.KeyPattern, "key-pattern", "", "regular expression written keys must match (empty allows any non-empty key)")
+	fs.StringVar(&cfg.StaticDir, "static-dir", "public", "directory served under /public/")
+	fs.StringVar(&cfg.IndexFile, "index-file", "views/index.html", "page served at / and /index")
+	fs.IntVar(&cfg.NotifyWorkers, "notify-workers", 4, "workers delivering change notifications")
+	fs.IntVar(&cfg.NotifyQueue, "notify-queue", 1024, "change notifications buffered before dropping")
+	fs.StringVar(&cfg.NotifyDrop, "notify-drop", "newest", `which notification to drop when the queue is full: "newest" or "oldest"`)
+	fs.BoolVar(&cfg.VerboseStats, "verbose-stats", false, "log stats on every worker tick, not only when they change")
+	fs.IntVar(&cfg.MaxValueBytes, "max-value-bytes", 0, "largest value, as stored, a JSON write or list push may carry; larger ones get 413 (0 is unlimited; see -blob-max-bytes for uploads)")
+	fs.Int64Var(&cfg.BlobMaxBytes, "blob-max-bytes", 32<<20, "largest value a chunked upload may assemble")
+	fs.IntVar(&cfg.MaxUploads, "max-uploads", 16, "chunked uploads pending at once, each holding up to -blob-max-bytes in memory; further uploads get 503 (0 is unlimited)")
+	fs.Int64Var(&cfg.MaxRestoreBytes, "max-restore-bytes", 256<<20, "largest backup archive, as uploaded, POST /api/admin/restore accepts; larger ones get 413")
+	fs.DurationVar(&cfg.UploadTimeout, "upload-timeout", 10*time.Minute, "drop chunked uploads idle for longer than this")
+	fs.BoolVar(&cfg.CaseInsensitiveKeys, "case-insensitive-keys", false, "lowercase keys on write and lookup (existing keys differing only in case will collide)")
+	fs.BoolVar(&cfg.AccessLog, "access-log", false, "log one line per request")
+	fs.BoolVar(&cfg.LogDisconnects, "log-disconnects", false, "log requests whose client disconnected before the response was written, even without -access-log")
+	fs.IntVar(&cfg.PersistRetries, "persist-retries", 3, "retries for a failed snapshot before persistence is marked degraded")
+	fs.DurationVar(&cfg.PersistBackoff, "persist-backoff", 200*time.Millisecond, "initial backoff between persistence retries, doubled after each attempt")
+	fs.DurationVar(&cfg.ReadTimeout, "read-timeout", 30*time.Second, "time allowed for reading a whole request, so a body shorter than its Content-Length fails instead of hanging (0 is unlimited)")
+	fs.DurationVar(&cfg.IdleTimeout, "idle-timeout", 2*time.Minute, "how long an idle keep-alive connection stays open")
+	fs.BoolVar(&cfg.KeepAlives, "keep-alives", true, "reuse connections across requests (disable to close after every response)")
+	fs.IntVar(&cfg.MaxConnsPerIP, "max-conns-per-ip", 0, "connections one client IP may hold open at once; further ones are closed on accept (0 is unlimited)")
+	fs.IntVar(&cfg.MaxConcurrent, "max-concurrent", 0, "requests served at once; further requests wait for a slot (0 is unlimited)")
+	fs.StringVar(&cfg.NamespaceSeparator, "namespace-separator", ":", "separator ending the namespace prefix of a key, used for per-namespace stats (empty puts every key in the default namespace)")
+	fs.StringVar(&cfg.FaviconPath, "favicon-path", "/favicon.ico", "path the favicon is served at")
+	fs.StringVar(&cfg.FaviconFile, "favicon-file", "", "icon file served at -favicon-path (empty answers 204)")
+	fs.StringVar(&cfg.RobotsPath, "robots-path", "/robots.txt", "path robots.txt is served at")
+	fs.StringVar(&cfg.RobotsFile, "robots-file", "", "robots.txt served at -robots-path (empty disallows all crawling)")
+	fs.DurationVar(&cfg.CoalesceWindow, "coalesce-window", 0, "merge PUTs to the same key arriving within this window while another write to it is in flight, storing only the last; readers may see the old value for up to the window (0 disables)")
+	fs.DurationVar(&cfg.CORSMaxAge, "cors-max-age", 2*time.Hour, "how long browsers may cache a CORS preflight result (0 omits Access-Control-Max-Age)")
+	corsOrigins := fs.String("cors-origins", "", `comma-separated origins allowed to call the API from a browser, or "*" (empty disables CORS)`)
+	fs.DurationVar(&cfg.ShutdownTimeout, "shutdown-timeout", 5*time.Second, "time allowed for draining requests, and again for shutdown hooks")
+	fs.IntVar(&cfg.MaxSubscribers, "max-subscribers", 1000, "event streams open at once; further subscribers get 503 (0 is unlimited)")
+	fs.IntVar(&cfg.SubscriberBuffer, "subscriber-buffer", 64, "events buffered per event stream; a stream falling this far behind is disconnected")
+	fs.BoolVar(&cfg.APIOnly, "api-only", false, "serve only the API and /metrics; no static files, views, favicon or robots.txt")
+	fs.IntVar(&cfg.BufferResponses, "buffer-responses", 64<<10, "buffer responses up to this many bytes to send a Content-Length; larger ones are streamed (0 always streams)")
+	fs.IntVar(&cfg.MaxListLength, "max-list-length", 10000, "most values a list under /api/list/ may hold")
+	fs.IntVar(&cfg.KeysWarn, "keys-warn", 0, "log a warning when the number of keys reaches this (0 disables)")
+	fs.IntVar(&cfg.KeysCritical, "keys-critical", 0, "log an error when the number of keys reaches this (0 disables)")
+	fs.DurationVar(&cfg.WorkerInterval, "worker-interval", 5*time.Second, "background worker tick: expiry sweeps, stats logging and /api/stats/stream updates")
+	fs.DurationVar(&cfg.IdleLogAfter, "idle-log-after", 0, "log an INFO line each time this much more time passes without a request (0 disables)")
+	fs.IntVar(&cfg.MaxBytes, "max-bytes", 0, "largest total size of keys and values; writes growing the store past it get 507 (0 is unlimited)")
+	fs.BoolVar(&cfg.Pretty, "pretty", false, "indent JSON responses unless the request has ?pretty=false (?pretty=true does it per request)")
+	fs.StringVar(&cfg.MessagesDir, "messages-dir", "", "directory of <lang>.json error message catalogs keyed by error code, chosen by Accept-Language (empty sends English only)")
+	fs.IntVar(&cfg.BreakerFailures, "breaker-failures", 5, "consecutive failed snapshots that pause writes with 503 (0 never pauses them)")
+	fs.DurationVar(&cfg.BreakerCooldown, "breaker-cooldown", 30*time.Second, "how long writes stay paused before persistence is retried")
+	namespaceQuotas := fs.String("namespace-quotas", "", "comma-separated per-namespace quotas, each NAMESPACE=KEYS/BYTES with 0 for unlimited and * for every other namespace (see NamespaceQuota)")
+	webhookURLs := fs.String("webhooks", "", "comma-separated URLs every change is POSTed to as JSON, asynchronously with retries (empty disables webhooks)")
+	fs.StringVar(&cfg.WebhookSecret, "webhook-secret", "", "key signing webhook bodies with HMAC-SHA256 in X-Webhook-Signature (empty sends them unsigned)")
+	fs.IntVar(&cfg.WebhookQueue, "webhook-queue", 1024, "changes buffered for webhook delivery before dropping")
+	fs.StringVar(&cfg.ReplicaURL, "replica-url", "", "base URL of an instance to mirror writes and deletes to, asynchronously and in order (empty disables replication)")
+	fs.IntVar(&cfg.ReplicaQueue, "replica-queue", 4096, "changes buffered for replication before dropping")
+	fs.StringVar(&cfg.LogOutput, "log-output", "stdout", `where logs go: "stdout", "stderr", "syslog" or a file path (reopened on SIGHUP)`)
+	fs.Int64Var(&cfg.LogMaxSize, "log-max-size", 0, "rotate a -log-output file once it grows past this many bytes (0 never rotates)")
+	fs.IntVar(&cfg.LogMaxFiles, "log-max-files", 5, "rotated log files kept as <path>.1 to <path>.N")
+	fs.StringVar(&cfg.SeedFile, "seed", "", "JSON file shaped like a POST /api/data body, loaded at startup only if the store is still empty (never written back)")
+	fs.StringVar(&cfg.PullFrom, "pull-from", "", "base URL of a peer to copy the whole dataset from at startup, before serving (empty disables)")
+	fs.BoolVar(&cfg.PullRequired, "pull-required", false, "exit when the -pull-from copy fails instead of warning and serving local data")
+	contentTypes := fs.String("content-types", "", "comma-separated media types JSON request bodies must be sent as, e.g. application/json; others get 415 (empty accepts any)")
+	gzipLevel := fs.String("gzip-level", "default", `-gzip compression level: 1 (fastest) to 9 (smallest), "default", "best-speed" or "best-compression"`)
+	methodOverrides := fs.String("method-overrides", "", "comma-separated methods a POST may switch to with X-HTTP-Method-Override or ?_method=, e.g. PUT,DELETE (empty disables overrides)")
+	rateLimits := fs.String("rate-limits", "", "comma-separated per-route rate limits, each [METHOD ]PATTERN=COUNT/UNIT[:BURST], first match wins (see RateLimit)")
+	transforms := fs.String("transforms", "", "comma-separated value transforms applied on write, in order: trim, lowercase, updated_at")
+	allowedMethods := fs.String("allowed-methods", "", "comma-separated HTTP methods accepted at all (empty allows every method a route supports)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	for _, m := range strings.Split(*allowedMethods, ",") {
+		if m = strings.ToUpper(strings.TrimSpace(m)); m != "" {
+			cfg.AllowedMethods = append(cfg.AllowedMethods, m)
+		}
+	}
+
+	cfg.CORSOrigins = splitList(*corsOrigins)
+	level, err := parseGzipLevel(*gzipLevel)
+	if err != nil {
+		return nil, err
+	}
+	cfg.GzipLevel = level
+	for _, peer := range []struct {
+		flag string
+		url  *string
+	}{{"replica-url", &cfg.ReplicaURL}, {"pull-from", &cfg.PullFrom}} {
+		if *peer.url == "" {
+			continue
+		}
+		u, err := url.Parse(*peer.url)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("invalid -%s %q: want http(s)://host[:port]", peer.flag, *peer.url)
+		}
+		*peer.url = strings.TrimSuffix(*peer.url, "/")
+	}
+	cfg.Webhooks = splitList(*webhookURLs)
+	for _, u := range cfg.Webhooks {
+		if parsed, err := url.Parse(u); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return nil, fmt.Errorf("invalid -webhooks URL %q: want http(s)://host[:port]/path", u)
+		}
+	}
+	if cfg.ReplicaQueue < 1 {
+		return nil, fmt.Errorf("invalid -replica-queue %d: must be at least 1", cfg.ReplicaQueue)
+	}
+	if cfg.WebhookQueue < 1 {
+		return nil, fmt.Errorf("invalid -webhook-queue %d: must be at least 1", cfg.WebhookQueue)
+	}
+	for _, ct := range splitList(*contentTypes) {
+		cfg.ContentTypes = append(cfg.ContentTypes, strings.ToLower(ct))
+	}
+	for _, m := range splitList(*methodOverrides) {
+		switch m = strings.ToUpper(m); m {
+		case http.MethodPut, http.MethodDelete, http.MethodPatch:
+			cfg.MethodOverrides = append(cfg.MethodOverrides, m)
+		default:
+			return nil, fmt.Errorf("invalid -method-overrides: %s cannot be an override target", m)
+		}
+	}
+	limits, err := parseRateLimits(*rateLimits)
+	if err != nil {
+		return nil, fmt.Errorf("invalid -rate-limits: %w", err)
+	}
+	cfg.RateLimits = limits
+	if cfg.NamespaceQuotas, err = parseNamespaceQuotas(*namespaceQuotas); err != nil {
+		return nil, fmt.Errorf("invalid -namespace-quotas: %w", err)
+	}
+	if cfg.LogMaxSize < 0 || cfg.LogMaxFiles < 0 {
+		return nil, fmt.Errorf("-log-max-size and -log-max-files must not be negative")
+	}
+	if cfg.SubscriberBuffer < 1 {
+		return nil, fmt.Errorf("invalid -subscriber-buffer %d: must be at least 1", cfg.SubscriberBuffer)
+	}
+	if cfg.CORSMaxAge < 0 {
+		return nil, fmt.Errorf("invalid -cors-max-age %s: must not be negative", cfg.CORSMaxAge)
+	}
+
+	if cfg.KeysWarn < 0 || cfg.KeysCritical < 0 {
+		return nil, fmt.Errorf("-keys-warn and -keys-critical must not be negative")
+	}
+	if cfg.KeysWarn > 0 && cfg.KeysCritical > 0 && cfg.KeysWarn > cfg.KeysCritical {
+		return nil, fmt.Errorf("-keys-warn %d is above -keys-critical %d", cfg.KeysWarn, cfg.KeysCritical)
+	}
+
+	if cfg.WorkerInterval <= 0 {
+		return nil, fmt.Errorf("invalid -worker-interval %s: must be positive", cfg.WorkerInterval)
+	}
+
+	if cfg.MaxListLength <= 0 {
+		return nil, fmt.Errorf("invalid -max-list-length %d: must be positive", cfg.MaxListLength)
+	}
+
+	if cfg.MaxKeyLength <= 0 {
+		return nil, fmt.Errorf("invalid -max-key-length %d: must be positive", cfg.MaxKeyLength)
+	}
+
+	switch cfg.WorkerPanicMode {
+	case "restart", "crash":
+	default:
+		return nil, fmt.Errorf("invalid -worker-panic %q: want \"restart\" or \"crash\"", cfg.WorkerPanicMode)
+	}
+
+	for _, name := range strings.Split(*transforms, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := valueTransforms[name]; !ok {
+			return nil, fmt.Errorf("invalid -transforms: unknown transform %q", name)
+		}
+		cfg.Transforms = append(cfg.Transforms, name)
+	}
+
+	if cfg.PersistRetries < 0 {
+		return nil, fmt.Errorf("invalid -persist-retries %d: must not be negative", cfg.PersistRetries)
+	}
+
+	if cfg.NotifyWorkers <= 0 || cfg.NotifyQueue <= 0 {
+		return nil, fmt.Errorf("-notify-workers and -notify-queue must be positive")
+	}
+	switch cfg.NotifyDrop {
+	case "newest", "oldest":
+	default:
+		return nil, fmt.Errorf("invalid -notify-drop %q: want \"newest\" or \"oldest\"", cfg.NotifyDrop)
+	}
+
+	if cfg.KeyPattern != "" {
+		re, err := regexp.Compile(cfg.KeyPattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -key-pattern: %w", err)
+		}
+		cfg.KeyRegexp = re
+	}
+
+	if cfg.SnapshotPath != "" {
+		if err := checkSnapshotDir(cfg.SnapshotPath); err != nil {
+			return nil, fmt.Errorf("invalid -snapshot-path: %w", err)
+		}
+	}
+
+	if !cfg.APIOnly {
+		if err := checkBrowserFiles(cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// checkBrowserFiles validates the settings for static files and views, which
+// -api-only does not serve.
+func checkBrowserFiles(cfg *Config) error {
+	if fi, err := os.Stat(cfg.StaticDir); err != nil {
+		return fmt.Errorf("invalid -static-dir: %w", err)
+	} else if !fi.IsDir() {
+		return fmt.Errorf("invalid -static-dir: %s is not a directory", cfg.StaticDir)
+	}
+	if fi, err := os.Stat(cfg.IndexFile); err != nil {
+		return fmt.Errorf("invalid -index-file: %w", err)
+	} else if fi.IsDir() {
+		return fmt.Errorf("invalid -index-file: %s is a directory", cfg.IndexFile)
+	}
+
+	for flagName, p := range map[string]string{"favicon-path": cfg.FaviconPath, "robots-path": cfg.RobotsPath} {
+		if !strings.HasPrefix(p, "/") {
+			return fmt.Errorf("invalid -%s %q: must start with /", flagName, p)
+		}
+	}
+	for flagName, f := range map[string]string{"favicon-file": cfg.FaviconFile, "robots-file": cfg.RobotsFile} {
+		if f == "" {
+			continue
+		}
+		if fi, err := os.Stat(f); err != nil {
+			return fmt.Errorf("invalid -%s: %w", flagName, err)
+		} else if fi.IsDir() {
+			return fmt.Errorf("invalid -%s: %s is a directory", flagName, f)
+		}
+	}
+	return nil
+}
+
+// stripUserinfo drops any user:pass@ credentials from a URL before it is
+// shown, leaving anything that does not parse as a URL as it is.
+func stripUserinfo(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.User == nil {
+		return raw
+	}
+	u.User = nil
+	return u.String()
+}
+
+// redacted returns the config keyed by JSON field name, with durations in
+// readable form, every non-empty redact:"true" field masked and credentials
+// stripped from redact:"url" fields.
+func (c *Config) redacted() map[string]interface{} {
+	out := make(map[string]interface{})
+	v := reflect.ValueOf(c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+
+		fv := v.Field(i)
+		switch {
+		case f.Tag.Get("redact") == "true":
+			if fv.IsZero() {
+				out[name] = ""
+			} else {
+				out[name] = "[REDACTED]"
+			}
+		case f.Tag.Get("redact") == "url" && f.Type.Kind() == reflect.String:
+			out[name] = stripUserinfo(fv.String())
+		case f.Tag.Get("redact") == "url":
+			urls := make([]string, fv.Len())
+			for j := range urls {
+				urls[j] = stripUserinfo(fv.Index(j).String())
+			}
+			out[name] = urls
+		case f.Type == reflect.TypeOf(time.Duration(0)):
+			out[name] = fv.Interface().(time.Duration).String()
+		default:
+			out[name] = fv.Interface()
+		}
+	}
+	return out
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// BenchmarkKeepAlive sends small sequential requests from one client and
+// reports how many connections the server accepted per request, with
+// keep-alives on and off.
+func BenchmarkKeepAlive(b *testing.B) {
+	for _, tc := range []struct {
+		name string
+		args []string
+	}{
+		{"on", nil},
+		{"off", []string{"-keep-alives=false"}},
+	} {
+		b.Run(tc.name, func(b *testing.B) {
+			s, _ := newTestServer(b, tc.args...)
+			fillKeys(s, 1)
+
+			var conns atomic.Int64
+			ts := httptest.NewUnstartedServer(nil)
+			ts.Config = newHTTPServer(s.cfg, s.routes())
+			ts.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					conns.Add(1)
+				}
+			}
+			ts.Start()
+			defer ts.Close()
+			client := ts.Client()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, err := client.Get(ts.URL + "/api/data/key0")
+				if err != nil {
+					b.Fatal(err)
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					b.Fatalf("status %d", resp.StatusCode)
+				}
+			}
+			b.ReportMetric(float64(conns.Load())/float64(b.N), "conns/op")
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+// perIPListener caps how many connections one client IP may hold open at
+// once, below request rate limiting: a connection over -max-conns-per-ip is
+// closed as soon as it is accepted, before any request is read from it.
+// Refusals are counted rather than logged, so a hoarding client cannot flood
+// the log.
+type perIPListener struct {
+	net.Listener
+	max int
+
+	mu     sync.Mutex
+	active map[string]int
+
+	rejected atomic.Int64
+}
+
+func newPerIPListener(ln net.Listener, max int) *perIPListener {
+	return &perIPListener{Listener: ln, max: max, active: make(map[string]int)}
+}
+
+func (l *perIPListener) Accept() (net.Conn, error) {
+	for {
+		c, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		ip := remoteIP(c)
+		l.mu.Lock()
+		over := l.active[ip] >= l.max
+		if !over {
+			l.active[ip]++
+		}
+		l.mu.Unlock()
+		if over {
+			l.rejected.Add(1)
+			c.Close()
+			continue
+		}
+		return &trackedConn{Conn: c, release: func() { l.release(ip) }}, nil
+	}
+}
+
+func (l *perIPListener) release(ip string) {
+	l.mu.Lock()
+	if l.active[ip]--; l.active[ip] <= 0 {
+		delete(l.active, ip)
+	}
+	l.mu.Unlock()
+}
+
+func remoteIP(c net.Conn) string {
+	host, _, err := net.SplitHostPort(c.RemoteAddr().String())
+	if err != nil {
+		return c.RemoteAddr().String()
+	}
+	return host
+}
+
+// trackedConn gives its slot back on the first Close.
+type trackedConn struct {
+	net.Conn
+	once    sync.Once
+	release func()
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(c.release)
+	return c.Conn.Close()
+}
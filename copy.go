@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// copyHandler serves POST /api/data/{key}/copyfrom/{src}, copying the value
+// of src to key in one critical section so src cannot change halfway. An
+// existing destination is only replaced with ?overwrite=true. The copy
+// expires like any other write (-default-ttl or ?ttl=), unless
+// ?keep_ttl=true gives it src's expiry.
+func (s *Server) copyHandler(w http.ResponseWriter, r *http.Request) {
+	dst, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+	src, ok := s.keyParam(w, r, "src")
+	if !ok {
+		return
+	}
+	if err := s.validateKey(dst); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidKey, err.Error())
+		return
+	}
+	overwrite := r.URL.Query().Get("overwrite") == "true"
+	keepTTL := r.URL.Query().Get("keep_ttl") == "true"
+	expiresAt, err := s.expiryFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+		return
+	}
+
+	now := time.Now()
+	s.lock()
+	s.incRequests(src, dst)
+	e, found := s.data.Get(src)
+	if !found || e.expired(now) {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, codeKeyNotFound, fmt.Sprintf("Source key %q not found", src))
+		return
+	}
+	if old, exists := s.data.Get(dst); exists && !old.expired(now) && !overwrite {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, codeKeyExists, fmt.Sprintf("Key %q exists; add ?overwrite=true to replace it", dst))
+		return
+	}
+	if err := s.checkCapacityLocked(map[string]string{dst: e.Value}); err != nil {
+		s.mu.Unlock()
+		s.writeCapacityError(w, err)
+		return
+	}
+	if keepTTL {
+		expiresAt = e.ExpiresAt
+	}
+	s.setLocked(dst, e.Value, expiresAt)
+	s.notifier.publish(changeEvent{Op: "set", Key: dst, Value: e.Value, Time: now})
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": dst, "source": src, "ttl": ttlSeconds(entry{ExpiresAt: expiresAt}, true, now)})
+}
+
+// renameHandler serves POST /api/data/{key}/rename with {"to": key}, moving
+// the value with its modification time and expiry to the new key and deleting
+// the old one in a single critical section. An existing destination is only
+// replaced with "overwrite": true in the body or ?overwrite=true.
+func (s *Server) renameHandler(w http.ResponseWriter, r *http.Request) {
+	src, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		To        string `json:"to"`
+		Overwrite bool   `json:"overwrite"`
+	}
+	if err := s.decodeJSONBody(r, &body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	dst := s.normalizeKey(body.To)
+	if err := s.validateKey(dst); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidKey, err.Error())
+		return
+	}
+	if dst == src {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, `"to" must differ from the key being renamed`)
+		return
+	}
+	overwrite := body.Overwrite || r.URL.Query().Get("overwrite") == "true"
+
+	now := time.Now()
+	s.lock()
+	s.incRequests(src, dst)
+	e, found := s.data.Get(src)
+	if !found || e.expired(now) {
+		s.mu.Unlock()
+		writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+		return
+	}
+	if old, exists := s.data.Get(dst); exists && !old.expired(now) && !overwrite {
+		s.mu.Unlock()
+		writeError(w, http.StatusConflict, codeKeyExists, fmt.Sprintf("Key %q exists; set overwrite to replace it", dst))
+		return
+	}
+	// The source is removed before the capacity check so a rename within a
+	// full namespace still fits, and put back if the destination does not.
+	s.deleteLocked(src)
+	if err := s.checkCapacityLocked(map[string]string{dst: e.Value}); err != nil {
+		s.setLocked(src, e.Value, e.ExpiresAt)
+		s.data.Set(src, e)
+		s.mu.Unlock()
+		s.writeCapacityError(w, err)
+		return
+	}
+	s.setLocked(dst, e.Value, e.ExpiresAt)
+	moved, _ := s.data.Get(dst)
+	moved.Modified = e.Modified
+	s.data.Set(dst, moved)
+	s.notifier.publish(changeEvent{Op: "delete", Key: src, Time: now})
+	s.notifier.publish(changeEvent{Op: "set", Key: dst, Value: e.Value, Time: now})
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": dst, "from": src, "ttl": ttlSeconds(e, true, now)})
+}
@@ -0,0 +1,18 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCopyFromValidatesSource(t *testing.T) {
+	_, ts := newTestServer(t, "-max-key-length", "8", "-case-insensitive-keys")
+	expect(t, ts, http.MethodPost, "/api/data", `{"src":"v"}`, http.StatusOK)
+
+	expect(t, ts, http.MethodPost, "/api/data/dst/copyfrom/"+strings.Repeat("x", 9), "", http.StatusRequestURITooLong)
+	expect(t, ts, http.MethodPost, "/api/data/dst/copyfrom/SRC", "", http.StatusOK)
+	if got := getValue(t, ts, "dst"); got != "v" {
+		t.Fatalf("copied %q, want %q", got, "v")
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// corsMiddleware lets browsers on -cors-origins call the API. Preflight
+// requests are answered here with 204 and an Access-Control-Max-Age, so a
+// browser can reuse the result instead of preflighting every call. The
+// allowed methods are the path's Allow header, as rt.allowedMethods reports
+// it; a preflight for an unknown path gets none.
+func (s *Server) corsMiddleware(rt *router, next http.Handler) http.Handler {
+	origins := make(map[string]bool, len(s.cfg.CORSOrigins))
+	for _, o := range s.cfg.CORSOrigins {
+		origins[o] = true
+	}
+	maxAge := strconv.Itoa(int(s.cfg.CORSMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" || !(origins["*"] || origins[origin]) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		h := w.Header()
+		h.Add("Vary", "Origin")
+		h.Set("Access-Control-Allow-Origin", origin)
+		h.Set("Access-Control-Expose-Headers", "ETag, Last-Modified, Retry-After")
+
+		if r.Method != http.MethodOptions || r.Header.Get("Access-Control-Request-Method") == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if methods := rt.allowedMethods(r.URL.Path); methods != nil {
+			h.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		}
+		if reqHeaders := r.Header.Get("Access-Control-Request-Headers"); reqHeaders != "" {
+			h.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		if s.cfg.CORSMaxAge > 0 {
+			h.Set("Access-Control-Max-Age", maxAge)
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
+
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
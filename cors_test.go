@@ -0,0 +1,35 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestCORSPreflightMethodsFollowRoutes(t *testing.T) {
+	_, ts := newTestServer(t, "-cors-origins", "*", "-allowed-methods", "GET,PUT")
+
+	preflight := func(path string) string {
+		req, _ := http.NewRequest(http.MethodOptions, ts.URL+path, nil)
+		req.Header.Set("Origin", "https://example.com")
+		req.Header.Set("Access-Control-Request-Method", http.MethodPut)
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusNoContent {
+			t.Fatalf("preflight %s: status %d, want 204", path, resp.StatusCode)
+		}
+		return resp.Header.Get("Access-Control-Allow-Methods")
+	}
+
+	if got, want := preflight("/api/data/k"), "GET, OPTIONS, PUT"; got != want {
+		t.Errorf("/api/data/k allows %q, want %q", got, want)
+	}
+	if got, want := preflight("/api/stats"), "GET, OPTIONS"; got != want {
+		t.Errorf("/api/stats allows %q, want %q", got, want)
+	}
+	if got := preflight("/nowhere"); got != "" {
+		t.Errorf("unknown path allows %q, want none", got)
+	}
+}
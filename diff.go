@@ -0,0 +1,64 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+)
+
+type dataDiff struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Changed []string `json:"changed"`
+}
+
+// diffHandler compares the posted client view of the map with the server's.
+// Added keys exist only on the server, removed keys only in the client view
+// and changed keys exist in both with different values.
+func (s *Server) diffHandler(w http.ResponseWriter, r *http.Request) {
+	raw, err := s.decodePayload(r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	client := make(map[string]string, len(raw))
+	for k, v := range raw {
+		str, err := s.valueString(v)
+		if err != nil {
+			writeValueError(w, err, "Values must be strings or numbers")
+			return
+		}
+		client[s.normalizeKey(k)] = str
+	}
+
+	diff := dataDiff{Added: []string{}, Removed: []string{}, Changed: []string{}}
+	now := time.Now()
+
+	s.lock()
+	s.incRequests()
+	s.data.Range(func(k string, e entry) bool {
+		if e.expired(now) {
+			return true
+		}
+		v, ok := client[k]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, k)
+		case v != e.Value:
+			diff.Changed = append(diff.Changed, k)
+		}
+		return true
+	})
+	for k := range client {
+		if e, ok := s.data.Get(k); !ok || e.expired(now) {
+			diff.Removed = append(diff.Removed, k)
+		}
+	}
+	s.mu.Unlock()
+
+	sort.Strings(diff.Added)
+	sort.Strings(diff.Removed)
+	sort.Strings(diff.Changed)
+
+	writeJSON(w, http.StatusOK, diff)
+}
@@ -0,0 +1,54 @@
+package main
+
+import "net/http"
+
+// Error codes returned in the "code" field of JSON error responses. Clients
+// should branch on these rather than on the human-readable message.
+const (
+	codeInvalidJSON            = "invalid_json"            // body is not the expected JSON
+	codeValidationFailed       = "validation_failed"       // one or more keys/values rejected; see "errors"
+	codeInvalidKey             = "invalid_key"             // key is empty or violates -key-pattern
+	codeKeyTooLong             = "key_too_long"            // key exceeds -max-key-length
+	codeKeyExists              = "key_exists"              // destination exists and overwriting was not asked for
+	codeKeyNotFound            = "key_not_found"           // key does not exist or has expired
+	codeInvalidParameter       = "invalid_parameter"       // malformed query parameter (limit, cursor, ...)
+	codeInvalidTTL             = "invalid_ttl"             // ttl is not a positive number of seconds or -1
+	codePreconditionFailed     = "precondition_failed"     // If-Unmodified-Since or X-Lock-Token check failed
+	codeTooManyKeys            = "too_many_keys"           // full dump refused; paginate instead
+	codeNotFound               = "not_found"               // no route for the path
+	codeMethodNotAllowed       = "method_not_allowed"      // route exists but not for this method; see Allow
+	codeRateLimited            = "rate_limited"            // try again after Retry-After
+	codeUnauthorized           = "unauthorized"            // missing or wrong X-Admin-Key
+	codeAdminDisabled          = "admin_disabled"          // no -admin-key configured
+	codeSnapshotsDisabled      = "snapshots_disabled"      // no -snapshot-path configured
+	codeShuttingDown           = "shutting_down"           // server is draining; retry elsewhere or later
+	codeChunkOutOfOrder        = "chunk_out_of_order"      // blob chunk number is not the next expected one
+	codeTooManyUploads         = "too_many_uploads"        // -max-uploads chunked uploads already pending
+	codeValueTooLarge          = "value_too_large"         // value exceeds the configured size limit
+	codeTooManySubscribers     = "too_many_subscribers"    // -max-subscribers event streams already open
+	codeChecksumMismatch       = "checksum_mismatch"       // body does not match Content-MD5 or X-Checksum-SHA256
+	codeListFull               = "list_full"               // push would exceed -max-list-length
+	codeQuotaExceeded          = "quota_exceeded"          // write would exceed the namespace's -namespace-quotas entry
+	codeStoreFull              = "store_full"              // write would exceed -max-bytes
+	codeNotAcceptable          = "not_acceptable"          // value cannot be returned in the requested representation
+	codePersistenceUnavailable = "persistence_unavailable" // persistence breaker open; retry after Retry-After
+	codeTruncatedBody          = "truncated_body"          // body ended before the JSON value did
+	codeUnsupportedMediaType   = "unsupported_media_type"  // Content-Type not accepted by -content-types
+	codeTrailingData           = "trailing_data"           // body has more after the JSON value
+	codeInvalidBackup          = "invalid_backup"          // restore body is not a supported backup archive
+	codeReplicating            = "replicating"             // restore refused while -replica-url is set
+	codeInternal               = "internal_error"          // unexpected server failure
+)
+
+type apiError struct {
+	Error string `json:"error"`
+	Code  string `json:"code"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	writeJSON(w, status, apiError{Error: localizedMessage(w, code, msg), Code: code})
+}
+
+func jsonNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, http.StatusNotFound, codeNotFound, "Not found")
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestErrorCodes(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		args    []string
+		setup   string // JSON posted to /api/data first, if any
+		prepare func(s *Server)
+		method  string
+		path    string
+		header  http.Header
+		body    string
+		status  int
+		code    string
+	}{
+		{name: "invalid json", method: http.MethodPost, path: "/api/data", body: `{"k":`, status: http.StatusBadRequest, code: codeTruncatedBody},
+		{name: "not an object", method: http.MethodPost, path: "/api/data", body: `[1]`, status: http.StatusBadRequest, code: codeInvalidJSON},
+		{name: "trailing data", method: http.MethodPost, path: "/api/data", body: `{"k":"v"} x`, status: http.StatusBadRequest, code: codeTrailingData},
+		{name: "empty key", method: http.MethodPost, path: "/api/data", body: `{"":"v"}`, status: http.StatusUnprocessableEntity, code: codeValidationFailed},
+		{name: "key too long", args: []string{"-max-key-length", "3"}, method: http.MethodGet, path: "/api/data/abcd", status: http.StatusRequestURITooLong, code: codeKeyTooLong},
+		{name: "missing key", method: http.MethodGet, path: "/api/data/missing", status: http.StatusNotFound, code: codeKeyNotFound},
+		{name: "delete missing key", method: http.MethodDelete, path: "/api/data/missing", status: http.StatusNotFound, code: codeKeyNotFound},
+		{name: "copy onto existing", setup: `{"a":"1","b":"2"}`, method: http.MethodPost, path: "/api/data/b/copyfrom/a", status: http.StatusConflict, code: codeKeyExists},
+		{name: "bad limit", method: http.MethodGet, path: "/api/data?limit=x", status: http.StatusBadRequest, code: codeInvalidParameter},
+		{name: "bad ttl", setup: `{"a":"1"}`, method: http.MethodPut, path: "/api/data/a/ttl", body: `{"ttl":0}`, status: http.StatusBadRequest, code: codeInvalidTTL},
+		{name: "too many keys", args: []string{"-max-full-get-keys", "1"}, setup: `{"a":"1","b":"2"}`, method: http.MethodGet, path: "/api/data", status: http.StatusRequestEntityTooLarge, code: codeTooManyKeys},
+		{name: "no route", method: http.MethodGet, path: "/api/nothing", status: http.StatusNotFound, code: codeNotFound},
+		{name: "wrong method", method: http.MethodPatch, path: "/api/data", status: http.StatusMethodNotAllowed, code: codeMethodNotAllowed},
+		{name: "admin disabled", method: http.MethodGet, path: "/api/admin/config", status: http.StatusForbidden, code: codeAdminDisabled},
+		{name: "wrong admin key", args: []string{"-admin-key", "secret"}, method: http.MethodGet, path: "/api/admin/config", status: http.StatusUnauthorized, code: codeUnauthorized},
+		{name: "value too large", args: []string{"-max-value-bytes", "4"}, method: http.MethodPut, path: "/api/data/a", body: `{"value":"12345"}`, status: http.StatusRequestEntityTooLarge, code: codeValueTooLarge},
+		{name: "store full", args: []string{"-max-bytes", "4"}, method: http.MethodPut, path: "/api/data/a", body: `{"value":"12345"}`, status: http.StatusInsufficientStorage, code: codeStoreFull},
+		{name: "list full", args: []string{"-max-list-length", "1"}, method: http.MethodPost, path: "/api/list/q/push", body: `{"values":["a","b"]}`, status: http.StatusConflict, code: codeListFull},
+		{name: "rate limited", args: []string{"-rate-limits", "POST /api/data=1/h"}, setup: `{"a":"1"}`, method: http.MethodPost, path: "/api/data", body: `{"b":"2"}`, status: http.StatusTooManyRequests, code: codeRateLimited},
+		{name: "shutting down", prepare: func(s *Server) { s.stopWrites() }, method: http.MethodPut, path: "/api/data/a", body: `{"value":"1"}`, status: http.StatusServiceUnavailable, code: codeShuttingDown},
+		{name: "precondition failed", setup: `{"a":"1"}`, method: http.MethodPut, path: "/api/data/a", header: http.Header{"If-Unmodified-Since": {"Mon, 01 Jan 2001 00:00:00 GMT"}}, body: `{"value":"2"}`, status: http.StatusPreconditionFailed, code: codePreconditionFailed},
+		{name: "checksum mismatch", method: http.MethodPost, path: "/api/data", header: http.Header{"Content-Md5": {"AAAAAAAAAAAAAAAAAAAAAA=="}}, body: `{"a":"1"}`, status: http.StatusBadRequest, code: codeChecksumMismatch},
+		{name: "quota exceeded", args: []string{"-namespace-separator", ":", "-namespace-quotas", "app=1/0"}, setup: `{"app:a":"1"}`, method: http.MethodPut, path: "/api/data/app:b", body: `{"value":"2"}`, status: http.StatusInsufficientStorage, code: codeQuotaExceeded},
+		{name: "unsupported media type", args: []string{"-content-types", "application/vnd.kv+json"}, method: http.MethodPut, path: "/api/data/a", body: `{"value":"1"}`, status: http.StatusUnsupportedMediaType, code: codeUnsupportedMediaType},
+		{name: "invalid backup", args: []string{"-admin-key", "secret"}, method: http.MethodPost, path: "/api/admin/restore", header: http.Header{"X-Admin-Key": {"secret"}}, body: `{"format":"kv-backup"}`, status: http.StatusBadRequest, code: codeInvalidBackup},
+		{name: "persistence unavailable", args: []string{"-breaker-failures", "1"}, prepare: func(s *Server) { s.breakerRecord(errors.New("disk full")) }, method: http.MethodPut, path: "/api/data/a", body: `{"value":"1"}`, status: http.StatusServiceUnavailable, code: codePersistenceUnavailable},
+		{name: "too many subscribers", args: []string{"-max-subscribers", "1"}, prepare: func(s *Server) { s.events.subscribe() }, method: http.MethodGet, path: "/api/events", status: http.StatusServiceUnavailable, code: codeTooManySubscribers},
+		{name: "chunk out of order", method: http.MethodPost, path: "/api/blob/b?chunk=1", body: "abc", status: http.StatusConflict, code: codeChunkOutOfOrder},
+		{name: "not acceptable", setup: `{"a":"not json"}`, method: http.MethodGet, path: "/api/data/a?as=value", status: http.StatusNotAcceptable, code: codeNotAcceptable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s, ts := newTestServer(t, tc.args...)
+			if tc.setup != "" {
+				expect(t, ts, http.MethodPost, "/api/data", tc.setup, http.StatusOK)
+			}
+			if tc.prepare != nil {
+				tc.prepare(s)
+			}
+			resp, body := doWithHeader(t, ts, tc.method, tc.path, tc.body, tc.header)
+			var got apiError
+			decodeBody(t, body, &got)
+			if resp.StatusCode != tc.status || got.Code != tc.code {
+				t.Fatalf("%s %s: %d %q, want %d %q; body %s", tc.method, tc.path, resp.StatusCode, got.Code, tc.status, tc.code, body)
+			}
+			if strings.TrimSpace(got.Error) == "" {
+				t.Fatalf("%s %s: empty error message", tc.method, tc.path)
+			}
+		})
+	}
+}
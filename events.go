@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+)
+
+// sseEvent is one server-sent event, encoded once and shared by every
+// subscriber it is sent to.
+type sseEvent struct {
+	name string
+	data []byte
+}
+
+type subscriber struct {
+	ch chan sseEvent
+	// dropped is closed when the hub disconnects the subscriber for falling
+	// behind.
+	dropped chan struct{}
+}
+
+// eventHub fans events out to the connected streams of one SSE endpoint.
+// With max > 0 at most max streams are open at once. Each stream buffers up
+// to buffer events; a stream whose buffer is full is disconnected rather than
+// allowed to block the sender or silently miss events.
+type eventHub struct {
+	name   string
+	mu     sync.Mutex
+	subs   map[*subscriber]struct{}
+	max    int
+	buffer int
+
+	closedOnShutdown atomic.Int64
+	rejected         atomic.Int64
+	slowDropped      atomic.Int64
+}
+
+func newEventHub(name string, max, buffer int) *eventHub {
+	return &eventHub{name: name, subs: make(map[*subscriber]struct{}), max: max, buffer: buffer}
+}
+
+// subscribe registers a new stream, or returns false when the hub is full.
+func (h *eventHub) subscribe() (*subscriber, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.max > 0 && len(h.subs) >= h.max {
+		h.rejected.Add(1)
+		return nil, false
+	}
+	sub := &subscriber{ch: make(chan sseEvent, h.buffer), dropped: make(chan struct{})}
+	h.subs[sub] = struct{}{}
+	return sub, true
+}
+
+func (h *eventHub) unsubscribe(sub *subscriber) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.mu.Unlock()
+}
+
+// send hands ev to every subscriber without blocking. A subscriber whose
+// buffer is full is too slow to keep up and is dropped.
+func (h *eventHub) send(ev sseEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for sub := range h.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			delete(h.subs, sub)
+			close(sub.dropped)
+			h.slowDropped.Add(1)
+			logger.Printf("Dropped slow %s subscriber: %d events unread\n", h.name, len(sub.ch))
+		}
+	}
+}
+
+// broadcast is the notifier sink for change events.
+func (h *eventHub) broadcast(ev changeEvent) {
+	b, _ := json.Marshal(ev)
+	h.send(sseEvent{name: ev.Op, data: b})
+}
+
+func (h *eventHub) count() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// eventsHandler streams change events as server-sent events.
+func (s *Server) eventsHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveStream(w, r, s.events)
+}
+
+// statsStreamHandler streams the stats on every background worker tick.
+func (s *Server) statsStreamHandler(w http.ResponseWriter, r *http.Request) {
+	s.serveStream(w, r, s.statsStream)
+}
+
+// publishStats sends the current stats to the stats streams, if any are
+// open.
+func (s *Server) publishStats() {
+	if s.statsStream.count() == 0 {
+		return
+	}
+	b, err := json.Marshal(s.stats())
+	if err != nil {
+		return
+	}
+	s.statsStream.send(sseEvent{name: "stats", data: b})
+}
+
+// serveStream relays hub's events to the client until it disconnects. When
+// the server shuts down each stream gets a final shutdown event and is
+// closed, so open streams do not hold up srv.Shutdown.
+func (s *Server) serveStream(w http.ResponseWriter, r *http.Request, hub *eventHub) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Streaming unsupported")
+		return
+	}
+
+	s.lock()
+	s.incRequests()
+	s.mu.Unlock()
+
+	sub, ok := hub.subscribe()
+	if !ok {
+		w.Header().Set("Retry-After", "5")
+		writeError(w, http.StatusServiceUnavailable, codeTooManySubscribers, "Too many event subscribers")
+		return
+	}
+	defer hub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case ev := <-sub.ch:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.name, ev.data)
+			flusher.Flush()
+		case <-sub.dropped:
+			return
+		case <-s.streamsCh:
+			fmt.Fprint(w, "event: shutdown\ndata: {}\n\n")
+			flusher.Flush()
+			hub.closedOnShutdown.Add(1)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// exportRecord is one line of GET /api/export, which streams the live data
+// as newline-delimited JSON in key order. ?prefix= limits the export to keys
+// starting with it, and ?namespace= to the keys of one namespace; for any
+// namespace but the default one that is the same as ?prefix=<ns><separator>.
+// A list is a record with its values in List and no Value or Modified; it
+// follows the string value of the same key, if any.
+type exportRecord struct {
+	Key       string     `json:"key"`
+	Value     string     `json:"value,omitempty"`
+	Modified  *time.Time `json:"modified,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	List      []string   `json:"list,omitempty"`
+}
+
+// Trailers sent after the export body, so clients can tell a complete export
+// from a truncated one: the number of records and the hex SHA-256 of the
+// body exactly as sent before any Content-Encoding.
+const (
+	trailerExportCount  = "X-Export-Count"
+	trailerExportSHA256 = "X-Export-Sha256"
+)
+
+// exportHandler copies the matching entries under the lock and encodes them
+// after releasing it, so a slow client never holds up writers.
+func (s *Server) exportHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+	ns, byNamespace := q.Get("namespace"), q.Has("namespace")
+	if byNamespace && ns == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, "namespace must not be empty")
+		return
+	}
+	if byNamespace && ns != defaultNamespace && s.cfg.NamespaceSeparator != "" {
+		prefix, byNamespace = ns+s.cfg.NamespaceSeparator+prefix, false
+	}
+
+	now := time.Now()
+	matches := func(k string) bool {
+		return strings.HasPrefix(k, prefix) && (!byNamespace || s.namespaceOf(k) == ns)
+	}
+	s.lists.mu.Lock()
+	s.lock()
+	s.incRequests()
+	records := make([]exportRecord, 0)
+	s.data.Range(func(k string, e entry) bool {
+		if e.expired(now) || !matches(k) {
+			return true
+		}
+		modified := e.Modified
+		rec := exportRecord{Key: k, Value: e.Value, Modified: &modified}
+		if !e.ExpiresAt.IsZero() {
+			exp := e.ExpiresAt
+			rec.ExpiresAt = &exp
+		}
+		records = append(records, rec)
+		return true
+	})
+	for k, list := range s.lists.lists {
+		if matches(k) {
+			records = append(records, exportRecord{Key: k, List: append([]string(nil), list...)})
+		}
+	}
+	s.mu.Unlock()
+	s.lists.mu.Unlock()
+	sort.Slice(records, func(i, j int) bool {
+		if records[i].Key != records[j].Key {
+			return records[i].Key < records[j].Key
+		}
+		return records[i].List == nil
+	})
+
+	h := w.Header()
+	h.Set("Content-Type", "application/x-ndjson")
+	h.Set("X-Content-Type-Options", "nosniff")
+	h.Set("Trailer", trailerExportCount+", "+trailerExportSHA256)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodHead {
+		return
+	}
+	// Send the headers now: trailer values set before the headers go out
+	// would be sent as headers too.
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+
+	sum := sha256.New()
+	bw := bufio.NewWriter(io.MultiWriter(w, sum))
+	enc := json.NewEncoder(bw)
+	for _, rec := range records {
+		if err := enc.Encode(rec); err != nil {
+			return
+		}
+	}
+	if err := bw.Flush(); err != nil {
+		return
+	}
+	h.Set(trailerExportCount, strconv.Itoa(len(records)))
+	h.Set(trailerExportSHA256, hex.EncodeToString(sum.Sum(nil)))
+}
@@ -1 +1,5 @@
 module github.com/mdinaramed/web_server
+
+go 1.21
+
+require golang.org/x/sync v0.7.0
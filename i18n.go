@@ -0,0 +1,73 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// messageCatalogs maps a language tag to its error messages keyed by error
+// code. Each <lang>.json file in -messages-dir holds one catalog; English is
+// built in and needs no file.
+type messageCatalogs map[string]map[string]string
+
+func loadMessageCatalogs(dir string) (messageCatalogs, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	catalogs := make(messageCatalogs, len(files))
+	for _, f := range files {
+		b, err := os.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var messages map[string]string
+		if err := json.Unmarshal(b, &messages); err != nil {
+			return nil, fmt.Errorf("parse %s: %w", f, err)
+		}
+		lang := strings.ToLower(strings.TrimSuffix(filepath.Base(f), ".json"))
+		catalogs[lang] = messages
+	}
+	return catalogs, nil
+}
+
+func (c messageCatalogs) languages() []string {
+	langs := make([]string, 0, len(c))
+	for lang := range c {
+		langs = append(langs, lang)
+	}
+	sort.Strings(langs)
+	return langs
+}
+
+// negotiate picks the catalog for an Accept-Language header: the supported
+// language with the highest q-value, matching "de-AT" against "de" when
+// there is no exact entry. It returns nil for English or no match.
+func (c messageCatalogs) negotiate(header string) (string, map[string]string) {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, q := parseCoding(part)
+		if tag == "" || q <= bestQ {
+			continue
+		}
+		primary, _, _ := strings.Cut(tag, "-")
+		if primary == "en" {
+			best, bestQ = "", q
+			continue
+		}
+		for _, candidate := range []string{tag, primary} {
+			if _, ok := c[candidate]; ok {
+				best, bestQ = candidate, q
+				break
+			}
+		}
+	}
+	if best == "" {
+		return "", nil
+	}
+	return best, c[best]
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// responseOptions records per-request choices about how JSON responses are
+// written, so helpers that only see the ResponseWriter can honour them:
+//
+//   - indented JSON with ?pretty=true, or by default with -pretty
+//     (?pretty=false turns it off), used by writeJSON;
+//   - error messages from the -messages-dir catalog best matching
+//     Accept-Language, used by writeError.
+func (s *Server) responseOptions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pretty := s.cfg.Pretty
+		switch r.URL.Query().Get("pretty") {
+		case "true", "1":
+			pretty = true
+		case "false", "0":
+			pretty = false
+		}
+		var messages map[string]string
+		if len(s.messages) > 0 {
+			if lang, m := s.messages.negotiate(r.Header.Get("Accept-Language")); m != nil {
+				w.Header().Set("Content-Language", lang)
+				messages = m
+			}
+			w.Header().Add("Vary", "Accept-Language")
+		}
+		if pretty || messages != nil {
+			w = &optionsResponseWriter{ResponseWriter: w, pretty: pretty, messages: messages}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+type optionsResponseWriter struct {
+	http.ResponseWriter
+	pretty   bool
+	messages map[string]string
+}
+
+func (o *optionsResponseWriter) Flush() {
+	if f, ok := o.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func wantsPretty(w http.ResponseWriter) bool {
+	o, ok := w.(*optionsResponseWriter)
+	return ok && o.pretty
+}
+
+// localizedMessage returns the catalog message for code in the request's
+// language, or msg when there is none.
+func localizedMessage(w http.ResponseWriter, code, msg string) string {
+	if o, ok := w.(*optionsResponseWriter); ok {
+		if m, ok := o.messages[code]; ok {
+			return m
+		}
+	}
+	return msg
+}
+
+// writeJSON sends v as a JSON response with the given status. Every JSON
+// response goes through it or writeJSONBytes, so they share headers and the
+// ?pretty option.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	setJSONHeaders(w, status)
+	enc := json.NewEncoder(w)
+	if wantsPretty(w) {
+		enc.SetIndent("", "  ")
+	}
+	if err := enc.Encode(v); err != nil {
+		logger.Println("Write JSON response:", err)
+	}
+}
+
+// writeJSONBytes is writeJSON for an already encoded body.
+func writeJSONBytes(w http.ResponseWriter, status int, b []byte) {
+	if wantsPretty(w) {
+		var buf bytes.Buffer
+		if json.Indent(&buf, b, "", "  ") == nil {
+			b = buf.Bytes()
+		}
+	}
+	setJSONHeaders(w, status)
+	// b may be shared with concurrent requests, so it is not appended to.
+	if _, err := w.Write(b); err != nil {
+		logger.Println("Write JSON response:", err)
+		return
+	}
+	w.Write([]byte("\n"))
+}
+
+func setJSONHeaders(w http.ResponseWriter, status int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+}
+
+var (
+	errEmptyBody     = errors.New("Request body is empty; expected a JSON object")
+	errTruncatedBody = errors.New("Request body ended in the middle of the JSON value; check Content-Length")
+	errTrailingData  = errors.New("Request body has data after the JSON value")
+)
+
+// unsupportedMediaTypeError is returned by decodeJSONBody when -content-types
+// is set and the request's Content-Type is not among them.
+type unsupportedMediaTypeError struct {
+	got     string
+	allowed []string
+}
+
+func (e *unsupportedMediaTypeError) Error() string {
+	got := e.got
+	if got == "" {
+		got = "no Content-Type"
+	}
+	return fmt.Sprintf("Unsupported media type %s; send Content-Type: %s", got, strings.Join(e.allowed, " or "))
+}
+
+// decodeJSONBody decodes exactly one JSON value from the request body into
+// v. Numbers in untyped values always decode as json.Number, so valueString
+// can tell whether they survive storage without -use-number. Empty and truncated bodies and bodies with
+// anything but whitespace after the value are reported as errEmptyBody,
+// errTruncatedBody and errTrailingData. With -content-types set, a request
+// of any other Content-Type is refused before its body is read.
+func (s *Server) decodeJSONBody(r *http.Request, v interface{}) error {
+	if err := s.checkContentType(r); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(r.Body)
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		switch {
+		case errors.Is(err, io.EOF):
+			return errEmptyBody
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errTruncatedBody
+		}
+		return err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return errTrailingData
+	}
+	return nil
+}
+
+func (s *Server) checkContentType(r *http.Request) error {
+	if len(s.cfg.ContentTypes) == 0 {
+		return nil
+	}
+	ct := r.Header.Get("Content-Type")
+	mt, _, err := mime.ParseMediaType(ct)
+	if err == nil {
+		for _, allowed := range s.cfg.ContentTypes {
+			if mt == allowed {
+				return nil
+			}
+		}
+	}
+	return &unsupportedMediaTypeError{got: ct, allowed: s.cfg.ContentTypes}
+}
+
+// writeDecodeError answers a decodeJSONBody error with 400, or 415 for an
+// unsupported Content-Type.
+func writeDecodeError(w http.ResponseWriter, err error) {
+	var mediaType *unsupportedMediaTypeError
+	if errors.As(err, &mediaType) {
+		writeError(w, http.StatusUnsupportedMediaType, codeUnsupportedMediaType, mediaType.Error())
+		return
+	}
+	switch err {
+	case errEmptyBody:
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, err.Error())
+	case errTruncatedBody:
+		writeError(w, http.StatusBadRequest, codeTruncatedBody, err.Error())
+	case errTrailingData:
+		writeError(w, http.StatusBadRequest, codeTrailingData, err.Error())
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid JSON")
+	}
+}
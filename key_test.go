@@ -0,0 +1,28 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestOversizedKeyPath(t *testing.T) {
+	s, ts := newTestServer(t, "-max-key-length", "8")
+	long := strings.Repeat("k", 9)
+	for _, method := range []string{http.MethodGet, http.MethodPut, http.MethodDelete} {
+		body := ""
+		if method == http.MethodPut {
+			body = `{"value":"v"}`
+		}
+		got := expect(t, ts, method, "/api/data/"+long, body, http.StatusRequestURITooLong)
+		if !strings.Contains(got, codeKeyTooLong) {
+			t.Errorf("%s: body %s lacks code %s", method, got, codeKeyTooLong)
+		}
+	}
+	if n := s.data.Len(); n != 0 {
+		t.Fatalf("oversized PUT stored %d keys", n)
+	}
+
+	expect(t, ts, http.MethodPut, "/api/data/"+long[:8], `{"value":"v"}`, http.StatusOK)
+	expect(t, ts, http.MethodGet, "/api/data/"+long[:8], "", http.StatusOK)
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+type pageItem struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type dataPage struct {
+	Items      []pageItem `json:"items"`
+	NextCursor string     `json:"next_cursor"`
+}
+
+// pageParams reads the ?limit= and ?cursor= parameters shared by the paginated
+// listings, answering 400 itself when they are malformed.
+func pageParams(w http.ResponseWriter, r *http.Request) (limit int, after string, hasCursor, ok bool) {
+	q := r.URL.Query()
+
+	limit = defaultPageLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidParameter, "Invalid limit")
+			return 0, "", false, false
+		}
+		if n > maxPageLimit {
+			n = maxPageLimit
+		}
+		limit = n
+	}
+
+	if c := q.Get("cursor"); c != "" {
+		b, err := base64.RawURLEncoding.DecodeString(c)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, codeInvalidParameter, "Invalid cursor")
+			return 0, "", false, false
+		}
+		after = string(b)
+		hasCursor = true
+	}
+	return limit, after, hasCursor, true
+}
+
+// listDataPage serves GET /api/data?cursor=&limit=. Keys are returned in
+// sorted order and the cursor encodes the last key of the previous page, so
+// paging stays stable while keys are inserted or deleted.
+func (s *Server) listDataPage(w http.ResponseWriter, r *http.Request) {
+	limit, after, hasCursor, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	s.lock()
+	s.incRequests()
+	version := s.version
+	s.mu.Unlock()
+
+	flightKey := fmt.Sprintf("list@%d limit=%d cursor=%t:%s", version, limit, hasCursor, after)
+	v, err, _ := s.reads.Do(flightKey, func() (interface{}, error) {
+		return json.Marshal(s.dataPage(after, hasCursor, limit))
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	writeJSONBytes(w, http.StatusOK, v.([]byte))
+}
+
+type keyEntry struct {
+	key string
+	e   entry
+}
+
+// pageEntries returns up to limit live entries after the cursor, in sorted
+// key order, and whether more follow. The matches are copied under s.mu and
+// sorted once it is released, so paging never sorts while writers wait.
+func (s *Server) pageEntries(after string, hasCursor bool, limit int, now time.Time) ([]keyEntry, bool) {
+	s.lock()
+	entries := make([]keyEntry, 0, s.data.Len())
+	s.data.Range(func(k string, e entry) bool {
+		if (!hasCursor || k > after) && !e.expired(now) {
+			entries = append(entries, keyEntry{k, e})
+		}
+		return true
+	})
+	s.mu.Unlock()
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	if len(entries) > limit {
+		return entries[:limit], true
+	}
+	return entries, false
+}
+
+func nextCursor(entries []keyEntry, more bool) string {
+	if !more {
+		return ""
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(entries[len(entries)-1].key))
+}
+
+func (s *Server) dataPage(after string, hasCursor bool, limit int) dataPage {
+	entries, more := s.pageEntries(after, hasCursor, limit, time.Now())
+	page := dataPage{Items: make([]pageItem, 0, len(entries))}
+	for _, ke := range entries {
+		page.Items = append(page.Items, pageItem{Key: ke.key, Value: ke.e.Value})
+	}
+	page.NextCursor = nextCursor(entries, more)
+	return page
+}
+
+type keyMetadata struct {
+	Key      string    `json:"key"`
+	Size     int       `json:"size"`
+	Modified time.Time `json:"modified"`
+	TTL      int       `json:"ttl"`
+}
+
+type metadataPage struct {
+	Items      []keyMetadata `json:"items"`
+	NextCursor string        `json:"next_cursor"`
+}
+
+// metadataHandler serves GET /api/data/metadata: the same pages as
+// listDataPage, but with each value's size in bytes, modification time and
+// TTL (as in GET /api/data/{key}/ttl) instead of the value itself.
+func (s *Server) metadataHandler(w http.ResponseWriter, r *http.Request) {
+	limit, after, hasCursor, ok := pageParams(w, r)
+	if !ok {
+		return
+	}
+
+	s.lock()
+	s.incRequests()
+	s.mu.Unlock()
+
+	now := time.Now()
+	entries, more := s.pageEntries(after, hasCursor, limit, now)
+	page := metadataPage{Items: make([]keyMetadata, 0, len(entries))}
+	for _, ke := range entries {
+		page.Items = append(page.Items, keyMetadata{
+			Key:      ke.key,
+			Size:     len(ke.e.Value),
+			Modified: ke.e.Modified,
+			TTL:      ttlSeconds(ke.e, true, now),
+		})
+	}
+	page.NextCursor = nextCursor(entries, more)
+	writeJSON(w, http.StatusOK, page)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// listStore holds list values apart from the string store, under its own
+// mutex; a list read holds s.mu only to count the request. A list counts as
+// one key of len(key) plus its values' bytes towards dataBytes, -max-bytes
+// and the namespace quotas, and that accounting lives under s.mu, so a push
+// or pop takes s.mu too while it holds mu. Lock order: lists.mu before s.mu,
+// never the reverse; snapshots, backups, exports and restores take both, in
+// that order, to see lists and entries at one instant. Lists never expire.
+type listStore struct {
+	mu    sync.Mutex
+	lists map[string][]string
+}
+
+func listBytes(key string, list []string) int {
+	n := len(key)
+	for _, v := range list {
+		n += len(v)
+	}
+	return n
+}
+
+// setListLocked replaces the list at key, keeping dataBytes and the
+// namespace usage in sync. An empty list deletes it. The caller must hold
+// s.lists.mu and s.mu.
+func (s *Server) setListLocked(key string, list []string) {
+	keys, delta := 0, 0
+	if old, ok := s.lists.lists[key]; ok {
+		keys, delta = -1, -listBytes(key, old)
+	}
+	if len(list) == 0 {
+		delete(s.lists.lists, key)
+	} else {
+		s.lists.lists[key] = list
+		keys, delta = keys+1, delta+listBytes(key, list)
+	}
+	s.dataBytes += delta
+	s.accountLocked(key, keys, delta)
+}
+
+// copyListsLocked returns a deep copy of every list. The caller must hold
+// s.lists.mu.
+func (s *Server) copyListsLocked() map[string][]string {
+	out := make(map[string][]string, len(s.lists.lists))
+	for k, list := range s.lists.lists {
+		out[k] = append([]string(nil), list...)
+	}
+	return out
+}
+
+// pushListHandler appends {"value": v} or {"values": [...]} to the end of the
+// list, creating it if needed. A push that would grow the list past
+// -max-list-length is rejected whole.
+func (s *Server) pushListHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := s.validateKey(key); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidKey, err.Error())
+		return
+	}
+
+	var body struct {
+		Value  interface{}   `json:"value"`
+		Values []interface{} `json:"values"`
+	}
+	if err := s.decodeJSONBody(r, &body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	raw := body.Values
+	if body.Value != nil {
+		raw = append([]interface{}{body.Value}, raw...)
+	}
+	if len(raw) == 0 {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, `Body needs "value" or "values"`)
+		return
+	}
+	values := make([]string, 0, len(raw))
+	for _, v := range raw {
+		str, err := s.valueString(v)
+		if err != nil {
+			writeValueError(w, err, "Values must be strings or numbers")
+			return
+		}
+		if s.valueTooLarge(str) {
+			writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, "Value too large: "+s.valueTooLargeMessage(str))
+			return
+		}
+		values = append(values, str)
+	}
+
+	s.lists.mu.Lock()
+	s.lock()
+	s.incRequests(key)
+	list := s.lists.lists[key]
+	if len(list)+len(values) > s.cfg.MaxListLength {
+		s.mu.Unlock()
+		s.lists.mu.Unlock()
+		writeError(w, http.StatusConflict, codeListFull, "List would exceed "+strconv.Itoa(s.cfg.MaxListLength)+" values")
+		return
+	}
+	if err := s.checkListPushLocked(key, values); err != nil {
+		s.mu.Unlock()
+		s.lists.mu.Unlock()
+		s.writeCapacityError(w, err)
+		return
+	}
+	list = append(list, values...)
+	s.setListLocked(key, list)
+	s.mu.Unlock()
+	length := len(list)
+	s.notifier.publish(changeEvent{Op: "push", Key: key, Values: values, Time: time.Now()})
+	s.lists.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "length": length})
+}
+
+func (s *Server) getListHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	s.lock()
+	s.incRequests(key)
+	s.mu.Unlock()
+
+	s.lists.mu.Lock()
+	list, found := s.lists.lists[key]
+	values := append([]string(nil), list...)
+	s.lists.mu.Unlock()
+
+	if !found {
+		writeError(w, http.StatusNotFound, codeKeyNotFound, "List not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "values": values})
+}
+
+// popListHandler removes and returns the first value, so push and pop make
+// the list a FIFO queue. Popping the last value deletes the list.
+func (s *Server) popListHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	s.lists.mu.Lock()
+	s.lock()
+	s.incRequests(key)
+	list := s.lists.lists[key]
+	if len(list) == 0 {
+		s.mu.Unlock()
+		s.lists.mu.Unlock()
+		writeError(w, http.StatusNotFound, codeKeyNotFound, "List not found")
+		return
+	}
+	value := list[0]
+	s.setListLocked(key, list[1:])
+	s.mu.Unlock()
+	list[0] = ""
+	length := len(list) - 1
+	s.notifier.publish(changeEvent{Op: "pop", Key: key, Time: time.Now()})
+	s.lists.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "value": value, "length": length})
+}
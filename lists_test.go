@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestListsSurviveSnapshot(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.json")
+	s, ts := newTestServer(t, "-snapshot-path", path)
+	expect(t, ts, http.MethodPost, "/api/list/q/push", `{"values":["a","b"]}`, http.StatusOK)
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored, _ := newTestServer(t, "-snapshot-path", path)
+	if _, err := restored.loadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if got := restored.lists.lists["q"]; !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("restored list %q, want [a b]", got)
+	}
+	if restored.dataBytes != s.dataBytes {
+		t.Fatalf("restored db_bytes %d, want %d", restored.dataBytes, s.dataBytes)
+	}
+}
+
+func TestListsCountTowardsCapacity(t *testing.T) {
+	s, ts := newTestServer(t, "-max-bytes", "10")
+	expect(t, ts, http.MethodPost, "/api/list/q/push", `{"value":"12345"}`, http.StatusOK)
+	if s.dataBytes != len("q")+5 {
+		t.Fatalf("db_bytes %d after push, want %d", s.dataBytes, len("q")+5)
+	}
+	expect(t, ts, http.MethodPost, "/api/list/q/push", `{"value":"12345"}`, http.StatusInsufficientStorage)
+
+	expect(t, ts, http.MethodPost, "/api/list/q/pop", "", http.StatusOK)
+	if s.dataBytes != 0 {
+		t.Fatalf("db_bytes %d after popping the last value, want 0", s.dataBytes)
+	}
+}
+
+func TestListsInNamespaceQuota(t *testing.T) {
+	_, ts := newTestServer(t, "-namespace-separator", ":", "-namespace-quotas", "app=1/0")
+	expect(t, ts, http.MethodPost, "/api/list/app:q/push", `{"value":"x"}`, http.StatusOK)
+	expect(t, ts, http.MethodPost, "/api/data", `{"app:k":"v"}`, http.StatusInsufficientStorage)
+	expect(t, ts, http.MethodPost, "/api/list/app:r/push", `{"value":"x"}`, http.StatusInsufficientStorage)
+}
+
+func TestListsInExportAndBackup(t *testing.T) {
+	s, ts := newTestServer(t, "-admin-key", "secret")
+	expect(t, ts, http.MethodPost, "/api/list/q/push", `{"values":["a","b"]}`, http.StatusOK)
+
+	export := expect(t, ts, http.MethodGet, "/api/export", "", http.StatusOK)
+	if !strings.Contains(export, `{"key":"q","list":["a","b"]}`) {
+		t.Fatalf("export %q has no list record", export)
+	}
+
+	admin := func(method, path string, body io.Reader) []byte {
+		req, _ := http.NewRequest(method, ts.URL+path, body)
+		req.Header.Set("X-Admin-Key", "secret")
+		resp, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s %s: status %d: %s", method, path, resp.StatusCode, b)
+		}
+		return b
+	}
+	archive := admin(http.MethodGet, "/api/admin/backup", nil)
+
+	expect(t, ts, http.MethodPost, "/api/list/q/pop", "", http.StatusOK)
+	expect(t, ts, http.MethodPost, "/api/list/q/pop", "", http.StatusOK)
+	admin(http.MethodPost, "/api/admin/restore", bytes.NewReader(archive))
+
+	s.lists.mu.Lock()
+	got := s.lists.lists["q"]
+	s.lists.mu.Unlock()
+	if !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Fatalf("restored list %q, want [a b]", got)
+	}
+}
+
+func TestListsAlongsideSnapshots(t *testing.T) {
+	s, ts := newTestServer(t, "-snapshot-path", filepath.Join(t.TempDir(), "snap.json"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 25; j++ {
+				expect(t, ts, http.MethodPost, "/api/list/q/push", `{"value":"v"}`, http.StatusOK)
+				expect(t, ts, http.MethodGet, "/api/list/q", "", http.StatusOK)
+				expect(t, ts, http.MethodPut, "/api/data/k", `{"value":"v"}`, http.StatusOK)
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		if _, err := json.Marshal(s.snapshotContents(time.Now())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wg.Wait()
+
+	s.lists.mu.Lock()
+	n := len(s.lists.lists["q"])
+	s.lists.mu.Unlock()
+	if n != 100 {
+		t.Fatalf("list length %d, want 100", n)
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import "time"
+
+// lock acquires s.mu for request handlers, recording how long the caller
+// waited unless -lock-metrics=false.
+func (s *Server) lock() {
+	if !s.cfg.LockMetrics {
+		s.mu.Lock()
+		return
+	}
+	start := time.Now()
+	s.mu.Lock()
+	wait := time.Since(start)
+
+	s.lockWaits++
+	s.lockWaitTotal += wait
+	if wait > s.lockWaitMax {
+		s.lockWaitMax = wait
+	}
+}
+
+// lockWaitStatsLocked returns the average and maximum lock wait in
+// microseconds. The caller must hold s.mu.
+func (s *Server) lockWaitStatsLocked() (avg, max int) {
+	if s.lockWaits == 0 {
+		return 0, 0
+	}
+	return int(s.lockWaitTotal.Microseconds() / int64(s.lockWaits)), int(s.lockWaitMax.Microseconds())
+}
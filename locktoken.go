@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"strconv"
+)
+
+// lockToken returns the opaque optimistic-lock token handed out with a key's
+// value. It is derived from the key's version, so any write to the key
+// invalidates it, and from the server epoch, so it does not survive a
+// restart.
+func (s *Server) lockToken(key string, e entry) string {
+	h := fnv.New64a()
+	fmt.Fprintf(h, "%s\x00%s\x00%d", s.epoch, key, e.Version)
+	return strconv.FormatUint(h.Sum64(), 36)
+}
+
+// checkLockTokenLocked enforces an X-Lock-Token header: the key must still
+// exist and not have been written since the token was issued. Requests
+// without the header always pass. The caller must hold s.mu.
+func (s *Server) checkLockTokenLocked(r *http.Request, key string) bool {
+	token := r.Header.Get("X-Lock-Token")
+	if token == "" {
+		return true
+	}
+	e, ok := s.data.Get(key)
+	return ok && s.lockToken(key, e) == token
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// logger carries every line the server logs. It writes to os.Stdout until
+// setupLogOutput points it at the -log-output destination. Each call is
+// written through before it returns, so nothing logged before a panic or
+// os.Exit is lost.
+var logger = log.New(os.Stdout, "", 0)
+
+// logOutput is the -log-output destination other than stdout: "stderr",
+// "syslog", or a file path. A file is reopened on SIGHUP, so logrotate can
+// move it away, and with -log-max-size it is rotated to path.1 ... path.N
+// once it grows past that.
+type logOutput struct {
+	path     string
+	maxSize  int64
+	maxFiles int
+
+	mu   sync.Mutex
+	dst  io.Writer
+	file *os.File
+	size int64
+}
+
+// setupLogOutput points logger at the configured destination. For "stdout"
+// it does nothing and returns nil.
+func setupLogOutput(cfg *Config) (*logOutput, error) {
+	l := &logOutput{maxSize: cfg.LogMaxSize, maxFiles: cfg.LogMaxFiles}
+	switch cfg.LogOutput {
+	case "", "stdout":
+		return nil, nil
+	case "stderr":
+		l.dst = os.Stderr
+	case "syslog":
+		w, err := openSyslog()
+		if err != nil {
+			return nil, fmt.Errorf("syslog: %w", err)
+		}
+		l.dst = w
+	default:
+		l.path = cfg.LogOutput
+		if err := l.reopen(); err != nil {
+			return nil, err
+		}
+	}
+	logger.SetOutput(l)
+
+	if l.path != "" {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				l.mu.Lock()
+				err := l.reopen()
+				l.mu.Unlock()
+				if err != nil {
+					fmt.Fprintln(os.Stderr, "Log reopen error:", err)
+				}
+			}
+		}()
+	}
+	return l, nil
+}
+
+// Write is called by logger with one complete log entry at a time.
+func (l *logOutput) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.path != "" && l.maxSize > 0 && l.size+int64(len(p)) > l.maxSize && l.size > 0 {
+		if err := l.rotate(); err != nil {
+			fmt.Fprintln(os.Stderr, "Log rotation error:", err)
+		}
+	}
+	n, err := l.dst.Write(p)
+	l.size += int64(n)
+	return n, err
+}
+
+// reopen opens the log file afresh, appending. The caller must hold l.mu
+// once logger writes to l.
+func (l *logOutput) reopen() error {
+	f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.file, l.dst, l.size = f, f, fi.Size()
+	return nil
+}
+
+// rotate shifts path.N-1 to path.N, ..., path to path.1, dropping the
+// oldest, and starts a new file. The caller must hold l.mu.
+func (l *logOutput) rotate() error {
+	for i := l.maxFiles - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1))
+	}
+	if l.maxFiles > 0 {
+		if err := os.Rename(l.path, l.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Truncate(l.path, 0); err != nil {
+		return err
+	}
+	return l.reopen()
+}
+
+// close points logger back at os.Stdout and closes the log file.
+func (l *logOutput) close() {
+	if l == nil {
+		return
+	}
+	logger.SetOutput(os.Stdout)
+	l.mu.Lock()
+	if l.file != nil {
+		l.file.Close()
+	}
+	l.mu.Unlock()
+}
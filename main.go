@@ -1,198 +1,935 @@
 package main
 
 import (
-	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash/fnv"
+	"html/template"
+	"math/big"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
+
+	"golang.org/x/sync/singleflight"
 )
 
 type Server struct {
-	mu         sync.Mutex
-	data       map[string]string
-	requests   int
-	shutdownCh chan struct{}
+	cfg           *Config
+	mu            sync.Mutex
+	data          Store
+	dataBytes     int
+	requests      int
+	statsRequests int
+	version       uint64
+	epoch         string
+	started       time.Time
+	nsRequests    map[string]int
+	nsUsage       map[string]*namespaceUsage
+	lockWaits     int
+	lockWaitTotal time.Duration
+	lockWaitMax   time.Duration
+	expiredKeys   int
+	shutdownCh    chan struct{}
+	streamsCh     chan struct{}
+	workerDone    chan struct{}
+	snap          snapshotState
+
+	reads       singleflight.Group
+	statsTmpl   *template.Template
+	notifier    *notifier
+	replica     *replicator
+	webhooks    *webhooks
+	connLimit   *perIPListener
+	events      *eventHub
+	statsStream *eventHub
+	uploads     uploadTable
+	metrics     responseMetrics
+
+	concurrency concurrencyGauge
+	coalescer   writeCoalescer
+	lists       listStore
+	limiters    []*rateLimiter
+	// reservedKeys are the sub-paths of /api/data/ taken by other routes.
+	reservedKeys map[string]bool
+	messages     messageCatalogs
+
+	coalescedWrites atomic.Int64
+	persistStats    persistStats
+	breaker         persistBreaker
+
+	writesMu      sync.RWMutex
+	writesStopped bool
+
+	hooksMu sync.Mutex
+	hooks   []shutdownHook
+
+	workerPanics atomic.Int64
+	lastRequest  atomic.Int64
+
+	clientDisconnects atomic.Int64
+	keyAlertLevel     atomic.Int64
 }
 
-func NewServer() *Server {
-	return &Server{
-		data:       make(map[string]string),
-		shutdownCh: make(chan struct{}),
+func NewServer(cfg *Config) *Server {
+	s := &Server{
+		cfg:         cfg,
+		data:        newStore(),
+		epoch:       strconv.FormatInt(time.Now().UnixNano(), 36),
+		started:     time.Now(),
+		nsRequests:  make(map[string]int),
+		nsUsage:     make(map[string]*namespaceUsage),
+		shutdownCh:  make(chan struct{}),
+		streamsCh:   make(chan struct{}),
+		workerDone:  make(chan struct{}),
+		notifier:    newNotifier(cfg.NotifyWorkers, cfg.NotifyQueue, cfg.NotifyDrop == "oldest"),
+		events:      newEventHub("events", cfg.MaxSubscribers, cfg.SubscriberBuffer),
+		statsStream: newEventHub("stats stream", cfg.MaxSubscribers, cfg.SubscriberBuffer),
+		uploads:     uploadTable{pending: make(map[string]*pendingUpload)},
+		coalescer:   writeCoalescer{pending: make(map[string]*coalescedWrite), active: make(map[string]int)},
+		lists:       listStore{lists: make(map[string][]string)},
+	}
+	s.lastRequest.Store(s.started.UnixNano())
+	s.notifier.addSink(s.events.broadcast)
+	if len(cfg.Webhooks) > 0 {
+		s.webhooks = newWebhooks(cfg.Webhooks, cfg.WebhookSecret, cfg.WebhookQueue)
+		s.notifier.addSink(s.webhooks.enqueue)
+	}
+	if cfg.ReplicaURL != "" {
+		s.replica = newReplicator(cfg.ReplicaURL, cfg.ReplicaQueue)
+		s.notifier.addOrderedSink(s.replica.enqueue)
+	}
+	for _, rule := range cfg.RateLimits {
+		s.limiters = append(s.limiters, &rateLimiter{rule: rule, buckets: make(map[string]*tokenBucket)})
 	}
+	if cfg.MaxConcurrent > 0 {
+		s.concurrency.sem = make(chan struct{}, cfg.MaxConcurrent)
+	}
+	return s
 }
 
-func (s *Server) incRequests() {
+// incRequests counts a request, and once per namespace among the keys it
+// addressed. The caller must hold s.mu.
+func (s *Server) incRequests(keys ...string) {
 	s.requests++
+	counted := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		if ns := s.namespaceOf(k); !counted[ns] {
+			counted[ns] = true
+			s.nsRequests[ns]++
+		}
+	}
+}
+
+// setLocked and deleteLocked keep dataBytes in sync with data and bump the
+// data version. The caller must hold s.mu.
+func (s *Server) setLocked(key, value string, expiresAt time.Time) {
+	delta, added := len(key)+len(value), 1
+	if old, ok := s.data.Get(key); ok {
+		delta -= len(key) + len(old.Value)
+		added = 0
+	}
+	s.version++
+	s.data.Set(key, entry{Value: value, Modified: time.Now(), ExpiresAt: expiresAt, Version: s.version})
+	s.dataBytes += delta
+	s.accountLocked(key, added, delta)
+}
+
+func (s *Server) deleteLocked(key string) bool {
+	old, ok := s.data.Get(key)
+	if !ok {
+		return false
+	}
+	s.data.Delete(key)
+	s.dataBytes -= len(key) + len(old.Value)
+	s.accountLocked(key, -1, -(len(key) + len(old.Value)))
+	s.version++
+	return true
+}
+
+// checkUnmodifiedSinceLocked enforces an If-Unmodified-Since header against the
+// given keys, returning the first key modified after the header time. Keys
+// that do not exist yet always pass. The caller must hold s.mu.
+func (s *Server) checkUnmodifiedSinceLocked(r *http.Request, keys ...string) (string, bool) {
+	since, err := http.ParseTime(r.Header.Get("If-Unmodified-Since"))
+	if err != nil {
+		return "", true
+	}
+	for _, k := range keys {
+		if e, ok := s.data.Get(k); ok && e.Modified.Truncate(time.Second).After(since) {
+			return k, false
+		}
+	}
+	return "", true
 }
 
 func (s *Server) postDataHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if err := verifyBodyChecksum(r); err != nil {
+		var mismatch *checksumMismatchError
+		if errors.As(err, &mismatch) {
+			writeError(w, http.StatusBadRequest, codeChecksumMismatch, "Body does not match its checksum: "+err.Error())
+		} else {
+			writeError(w, http.StatusBadRequest, codeInvalidJSON, "Failed to read body")
+		}
+		return
+	}
+	raw, err := s.decodePayload(r)
+	if err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	expiresAt, err := s.expiryFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
 		return
 	}
 
-	var payload map[string]string
-	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+	// Validate the whole payload before touching the store so a bad entry
+	// never leaves the earlier ones applied.
+	payload, keys, verrs, status := s.validatePayload(raw, expiresAt)
+	if len(verrs) > 0 {
+		writeJSON(w, status, map[string]interface{}{
+			"error":  localizedMessage(w, codeValidationFailed, "Validation failed"),
+			"code":   codeValidationFailed,
+			"errors": verrs,
+		})
 		return
 	}
 
-	s.mu.Lock()
-	for k, v := range payload {
-		s.data[k] = v
+	created, updated := []string{}, []string{}
+	expires := make(map[string]time.Time)
+
+	s.lock()
+	s.incRequests(keys...)
+	if k, ok := s.checkUnmodifiedSinceLocked(r, keys...); !ok {
+		s.mu.Unlock()
+		writeError(w, http.StatusPreconditionFailed, codePreconditionFailed, fmt.Sprintf("Key %q was modified after If-Unmodified-Since", k))
+		return
+	}
+	values := make(map[string]string, len(keys))
+	for _, k := range keys {
+		values[k] = payload[k].value
+	}
+	if err := s.checkCapacityLocked(values); err != nil {
+		s.mu.Unlock()
+		s.writeCapacityError(w, err)
+		return
+	}
+	now := time.Now()
+	for _, k := range keys {
+		pw := payload[k]
+		if e, ok := s.data.Get(k); ok && !e.expired(now) {
+			updated = append(updated, k)
+		} else {
+			created = append(created, k)
+		}
+		s.setLocked(k, pw.value, pw.expiresAt)
+		if !pw.expiresAt.IsZero() {
+			expires[k] = pw.expiresAt
+		}
+		s.notifier.publish(changeEvent{Op: "set", Key: k, Value: pw.value, Time: now})
 	}
-	s.incRequests()
 	s.mu.Unlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"status":  "ok",
+		"created": created,
+		"updated": updated,
+		"expires": expires,
+	})
+}
+
+// decodePayload reads a JSON object of key/value pairs. Numeric values are
+// left as json.Number for valueString to convert.
+func (s *Server) decodePayload(r *http.Request) (map[string]interface{}, error) {
+	var raw map[string]interface{}
+	if err := s.decodeJSONBody(r, &raw); err != nil {
+		return nil, err
+	}
+	return raw, nil
+}
+
+type validationError struct {
+	Key   string `json:"key"`
+	Error string `json:"error"`
+}
+
+type pendingWrite struct {
+	value     string
+	expiresAt time.Time
+}
+
+// validatePayload checks every key and value of a decoded payload and
+// converts the values to their stored form. A value is either a string or
+// number, or an object {"value": ..., "ttl": N} carrying its own TTL that
+// overrides defaultExpiry. All problems are reported, sorted by key. The
+// status is 422 when only keys were rejected and 400 when any value was
+// malformed.
+func (s *Server) validatePayload(raw map[string]interface{}, defaultExpiry time.Time) (map[string]pendingWrite, []string, []validationError, int) {
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	now := time.Now()
+	payload := make(map[string]pendingWrite, len(raw))
+	var verrs []validationError
+	status := http.StatusUnprocessableEntity
+	for _, k := range keys {
+		nk := s.normalizeKey(k)
+		if err := s.validateKey(nk); err != nil {
+			verrs = append(verrs, validationError{Key: k, Error: err.Error()})
+			continue
+		}
+		pw, err := s.parseWrite(raw[k], defaultExpiry, now)
+		var tooLarge *valueTooLargeError
+		if errors.As(err, &tooLarge) {
+			verrs = append(verrs, validationError{Key: k, Error: err.Error()})
+			if status != http.StatusBadRequest {
+				status = http.StatusRequestEntityTooLarge
+			}
+			continue
+		}
+		if err != nil {
+			verrs = append(verrs, validationError{Key: k, Error: err.Error()})
+			status = http.StatusBadRequest
+			continue
+		}
+		pw.value = s.transformValue(pw.value, now)
+		if s.valueTooLarge(pw.value) {
+			verrs = append(verrs, validationError{Key: k, Error: s.valueTooLargeMessage(pw.value)})
+			if status != http.StatusBadRequest {
+				status = http.StatusRequestEntityTooLarge
+			}
+			continue
+		}
+		payload[nk] = pw
+	}
+
+	keys = keys[:0]
+	for k := range payload {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return payload, keys, verrs, status
+}
+
+func (s *Server) parseWrite(v interface{}, defaultExpiry, now time.Time) (pendingWrite, error) {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		str, err := s.valueString(v)
+		if err != nil {
+			return pendingWrite{}, err
+		}
+		return pendingWrite{value: str, expiresAt: defaultExpiry}, nil
+	}
+
+	str, err := s.valueString(obj["value"])
+	if err != nil {
+		return pendingWrite{}, err
+	}
+	pw := pendingWrite{value: str, expiresAt: defaultExpiry}
+	if ttl, ok := obj["ttl"]; ok {
+		n, err := strconv.Atoi(fmt.Sprint(ttl))
+		if err != nil || n == 0 || n < -1 {
+			return pendingWrite{}, errInvalidTTL
+		}
+		pw.expiresAt = time.Time{}
+		if n > 0 {
+			pw.expiresAt = now.Add(time.Duration(n) * time.Second)
+		}
+	}
+	return pw, nil
+}
+
+// normalizeKey folds keys to lower case with -case-insensitive-keys. Keys
+// differing only in case then share one entry; in a single payload the key
+// that sorts last wins.
+func (s *Server) normalizeKey(key string) string {
+	if s.cfg.CaseInsensitiveKeys {
+		return strings.ToLower(key)
+	}
+	return key
+}
+
+var errValueType = errors.New("value must be a string or number")
+
+type inexactNumberError struct {
+	number string
+}
+
+func (e *inexactNumberError) Error() string {
+	return fmt.Sprintf("number %s cannot be stored exactly; send it as a string or enable -use-number", e.number)
+}
+
+// valueString converts a decoded value to its stored form. With -use-number
+// numbers keep the digits they were sent with. Otherwise they are stored as
+// float64 would print them, and a number that would read back as a
+// different value, such as an integer beyond 2^53, is refused rather than
+// silently rounded.
+func (s *Server) valueString(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		if s.cfg.UseNumber {
+			return v.String(), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return "", &inexactNumberError{number: v.String()}
+		}
+		str := strconv.FormatFloat(f, 'f', -1, 64)
+		sent, _ := new(big.Rat).SetString(v.String())
+		stored, _ := new(big.Rat).SetString(str)
+		if sent == nil || stored == nil || sent.Cmp(stored) != 0 {
+			return "", &inexactNumberError{number: v.String()}
+		}
+		return str, nil
+	}
+	// Arrays and objects are never stored, but one over -max-value-bytes is
+	// reported as too large rather than mistyped.
+	if b, err := json.Marshal(v); err == nil && s.valueTooLarge(string(b)) {
+		return "", &valueTooLargeError{msg: s.valueTooLargeMessage(string(b))}
+	}
+	return "", errValueType
+}
+
+// writeValueError answers 400 for a value valueString refused, or 413 for
+// one over -max-value-bytes. typeMessage is the handler's wording for a value
+// that is neither string nor number.
+func writeValueError(w http.ResponseWriter, err error, typeMessage string) {
+	var tooLarge *valueTooLargeError
+	if errors.As(err, &tooLarge) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, "Value too large: "+err.Error())
+		return
+	}
+	var inexact *inexactNumberError
+	if errors.As(err, &inexact) {
+		writeError(w, http.StatusBadRequest, codeInvalidJSON, "Invalid value: "+err.Error())
+		return
+	}
+	writeError(w, http.StatusBadRequest, codeInvalidJSON, typeMessage)
+}
+
+func (s *Server) putDataHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+	if err := s.validateKey(key); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, codeInvalidKey, err.Error())
+		return
+	}
+
+	var body struct {
+		Value interface{} `json:"value"`
+	}
+	if err := s.decodeJSONBody(r, &body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	value, err := s.valueString(body.Value)
+	if err != nil {
+		writeValueError(w, err, "Value must be a string or number")
+		return
+	}
+	value = s.transformValue(value, time.Now())
+	if s.valueTooLarge(value) {
+		writeError(w, http.StatusRequestEntityTooLarge, codeValueTooLarge, "Value too large: "+s.valueTooLargeMessage(value))
+		return
+	}
+	expiresAt, err := s.expiryFromRequest(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, codeInvalidTTL, err.Error())
+		return
+	}
+
+	// Conditional writes must be checked one by one, so they never coalesce.
+	var modified time.Time
+	conditional := r.Header.Get("If-Unmodified-Since") != "" || r.Header.Get("X-Lock-Token") != ""
+	if s.cfg.CoalesceWindow > 0 && !conditional {
+		if modified, err = s.coalescedSet(key, value, expiresAt); err != nil {
+			s.writeCapacityError(w, err)
+			return
+		}
+	} else {
+		s.lock()
+		s.incRequests(key)
+		if _, ok := s.checkUnmodifiedSinceLocked(r, key); !ok {
+			s.mu.Unlock()
+			writeError(w, http.StatusPreconditionFailed, codePreconditionFailed, "Key was modified after If-Unmodified-Since")
+			return
+		}
+		if !s.checkLockTokenLocked(r, key) {
+			s.mu.Unlock()
+			writeError(w, http.StatusPreconditionFailed, codePreconditionFailed, "Lock token is stale; the key was written since it was read")
+			return
+		}
+		if err := s.checkCapacityLocked(map[string]string{key: value}); err != nil {
+			s.mu.Unlock()
+			s.writeCapacityError(w, err)
+			return
+		}
+		s.setLocked(key, value, expiresAt)
+		e, _ := s.data.Get(key)
+		modified = e.Modified
+		s.notifier.publish(changeEvent{Op: "set", Key: key, Value: value, Time: modified})
+		s.mu.Unlock()
+	}
+
+	w.Header().Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
 }
 
 func (s *Server) getDataHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if q := r.URL.Query(); q.Has("cursor") || q.Has("limit") {
+		s.listDataPage(w, r)
 		return
 	}
 
-	s.mu.Lock()
+	s.lock()
 	s.incRequests()
-	copyData := make(map[string]string)
-	for k, v := range s.data {
-		copyData[k] = v
+	n := s.data.Len()
+	version := s.version
+	s.mu.Unlock()
+	if n > s.cfg.MaxFullGetKeys {
+		msg := fmt.Sprintf("Too many keys to return at once (%d > %d); use ?limit=N&cursor= to paginate", n, s.cfg.MaxFullGetKeys)
+		writeError(w, http.StatusRequestEntityTooLarge, codeTooManyKeys, msg)
+		return
+	}
+
+	// The data version changes on every mutation, so it doubles as the
+	// ETag of the whole map and lets pollers detect "no changes" cheaply.
+	if checkNotModified(w, r, fmt.Sprintf(`"%s-%d"`, s.epoch, version)) {
+		return
+	}
+
+	// Concurrent full dumps share one copy and encoding of the map. The
+	// flight key carries the data version, so a request arriving after a
+	// write never joins a computation that started before it.
+	v, err, _ := s.reads.Do(fmt.Sprintf("data@%d", version), func() (interface{}, error) {
+		s.lock()
+		copyData := s.liveDataLocked(time.Now())
+		s.mu.Unlock()
+		return json.Marshal(copyData)
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	writeJSONBytes(w, http.StatusOK, v.([]byte))
+}
+
+// keyFromPath returns the {key} path parameter. Keys longer than
+// -max-key-length are rejected with 414 before any lookup happens.
+func (s *Server) keyFromPath(w http.ResponseWriter, r *http.Request) (string, bool) {
+	return s.keyParam(w, r, "key")
+}
+
+// keyParam reads the key in path parameter name, case folded and checked
+// against -max-key-length, answering the request itself when it is unusable.
+func (s *Server) keyParam(w http.ResponseWriter, r *http.Request, name string) (string, bool) {
+	key := s.normalizeKey(pathParam(r, name))
+	if len(key) > s.cfg.MaxKeyLength {
+		writeError(w, http.StatusRequestURITooLong, codeKeyTooLong, "Key too long")
+		return "", false
+	}
+	if key == "" {
+		writeError(w, http.StatusBadRequest, codeInvalidKey, "Key not specified")
+		return "", false
+	}
+	return key, true
+}
+
+// validateKey checks a key that is about to be written against
+// -max-key-length and -key-pattern. Without a pattern any non-empty key is
+// accepted.
+func (s *Server) validateKey(key string) error {
+	if key == "" {
+		return errors.New("key must not be empty")
+	}
+	if s.reservedKeys[key] {
+		return fmt.Errorf("key %q is reserved for /api/data/%s", key, key)
+	}
+	if len(key) > s.cfg.MaxKeyLength {
+		return fmt.Errorf("key is %d bytes, longer than the %d byte limit", len(key), s.cfg.MaxKeyLength)
+	}
+	if s.cfg.KeyRegexp != nil && !s.cfg.KeyRegexp.MatchString(key) {
+		return fmt.Errorf("key %q does not match pattern %s", key, s.cfg.KeyPattern)
+	}
+	return nil
+}
+
+// getKeyHandler returns a single entry as JSON. Requests carrying a Range
+// header get the raw value bytes instead, with 206 partial responses and 416
+// for unsatisfiable ranges, so large values can be fetched in pieces.
+func (s *Server) getKeyHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
 	}
+	switch as := r.URL.Query().Get("as"); as {
+	case "", "json", "text", "value":
+	default:
+		writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("Unknown representation %q; want json, text or value", as))
+		return
+	}
+
+	now := time.Now()
+	s.lock()
+	s.incRequests(key)
+	e, found := s.data.Get(key)
 	s.mu.Unlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(copyData)
+	if !found || e.expired(now) {
+		writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+		return
+	}
+
+	w.Header().Add("Vary", "Accept")
+	w.Header().Set("Accept-Ranges", "bytes")
+	if r.Header.Get("Range") != "" {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		http.ServeContent(w, r, "", e.Modified, strings.NewReader(e.Value))
+		return
+	}
+
+	w.Header().Set("Last-Modified", e.Modified.UTC().Format(http.TimeFormat))
+	switch valueRepresentation(r) {
+	case "text":
+		if !utf8.ValidString(e.Value) {
+			writeError(w, http.StatusNotAcceptable, codeNotAcceptable, "Value is not valid UTF-8 text")
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write([]byte(e.Value))
+	case "value":
+		if !json.Valid([]byte(e.Value)) {
+			writeError(w, http.StatusNotAcceptable, codeNotAcceptable, "Value is not a JSON document")
+			return
+		}
+		writeJSONBytes(w, http.StatusOK, []byte(e.Value))
+	default:
+		writeJSON(w, http.StatusOK, map[string]string{"key": key, "value": e.Value, "lock_token": s.lockToken(key, e)})
+	}
+}
+
+// valueRepresentation picks how the single-key GET returns a value, from
+// ?as= or else Accept:
+//
+//   - "json" (the default): a JSON object with key, value and lock_token;
+//   - "text" (or Accept preferring text/plain): the bare value as
+//     text/plain, for values that are valid UTF-8;
+//   - "value": the stored value itself as application/json, for values that
+//     are JSON documents.
+//
+// A value that cannot be represented as asked for gets 406.
+func valueRepresentation(r *http.Request) string {
+	if as := r.URL.Query().Get("as"); as != "" {
+		return as
+	}
+	textQ, jsonQ := -1.0, -1.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mediaType, q := parseCoding(part)
+		switch mediaType {
+		case "text/plain":
+			textQ = q
+		case "application/json":
+			jsonQ = q
+		}
+	}
+	if textQ > 0 && textQ > jsonQ {
+		return "text"
+	}
+	return "json"
 }
 
 func (s *Server) deleteDataHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	if r.URL.Query().Get("return") == "true" {
+		s.getDelHandler(w, r)
 		return
 	}
-
-	parts := strings.Split(r.URL.Path, "/")
-	if len(parts) < 4 || parts[3] == "" {
-		http.Error(w, "Key not specified", http.StatusBadRequest)
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
 		return
 	}
-	key := parts[3]
 
-	s.mu.Lock()
-	s.incRequests()
-	_, ok := s.data[key]
-	if ok {
-		delete(s.data, key)
+	s.lock()
+	s.incRequests(key)
+	if ok = s.deleteLocked(key); ok {
+		s.notifier.publish(changeEvent{Op: "delete", Key: key, Time: time.Now()})
 	}
 	s.mu.Unlock()
 
 	if !ok {
-		http.Error(w, "Key not found", http.StatusNotFound)
+		writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"deleted": key})
+	writeJSON(w, http.StatusOK, map[string]string{"deleted": key})
 }
 
-func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+// getDelHandler serves POST /api/data/{key}/getdel and DELETE with
+// ?return=true: it reads and deletes a key in one critical section, so of
+// several consumers racing for the same key exactly one gets its value and
+// the others get 404.
+func (s *Server) getDelHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
 		return
 	}
 
-	s.mu.Lock()
-	s.incRequests()
-	stats := map[string]int{
-		"total_requests": s.requests,
-		"db_size":        len(s.data),
+	now := time.Now()
+	s.lock()
+	s.incRequests(key)
+	e, found := s.data.Get(key)
+	if found {
+		s.deleteLocked(key)
+		op := "delete"
+		if e.expired(now) {
+			op = "expire"
+		}
+		s.notifier.publish(changeEvent{Op: op, Key: key, Time: now})
 	}
 	s.mu.Unlock()
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+	if !found || e.expired(now) {
+		writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"key": key, "value": e.Value})
 }
 
-func (s *Server) startBackgroundWorker() {
-	ticker := time.NewTicker(5 * time.Second)
-	defer ticker.Stop()
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	s.lock()
+	s.incRequests()
+	s.statsRequests++
+	s.mu.Unlock()
+
+	stats := s.stats()
+	if r.URL.Query().Get("breakdown") == "true" {
+		stats["namespaces"] = s.namespaceBreakdown()
+	}
+	if checkNotModified(w, r, statsETag(stats)) {
+		return
+	}
 
-	for {
-		select {
-		case <-ticker.C:
-			s.mu.Lock()
-			fmt.Printf("Current Requests: %d, Database size: %d\n", s.requests, len(s.data))
-			s.mu.Unlock()
-		case <-s.shutdownCh:
-			fmt.Println("Worker Stopped")
-			return
+	writeJSON(w, http.StatusOK, stats)
+}
+
+// statsETag derives a weak ETag from the stats values. Every poll bumps
+// total_requests and the status and lock-wait counters, so those are left out
+// and only requests other than stats polls are counted; a dashboard polling
+// an otherwise idle server then keeps getting 304s.
+func statsETag(stats map[string]interface{}) string {
+	stable := make(map[string]interface{}, len(stats))
+	for k, v := range stats {
+		switch k {
+		case "total_requests", "stats_requests", "status_codes", "status_classes", "lock_wait_avg_us", "lock_wait_max_us":
+		default:
+			stable[k] = v
+		}
+	}
+	stable["other_requests"] = stats["total_requests"].(int) - stats["stats_requests"].(int)
+
+	b, _ := json.Marshal(stable)
+	h := fnv.New64a()
+	h.Write(b)
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+func (s *Server) stats() map[string]interface{} {
+	s.mu.Lock()
+	lockWaitAvg, lockWaitMax := s.lockWaitStatsLocked()
+	stats := map[string]interface{}{
+		"total_requests":     s.requests,
+		"stats_requests":     s.statsRequests,
+		"db_size":            s.data.Len(),
+		"db_bytes":           s.dataBytes,
+		"max_bytes":          s.cfg.MaxBytes,
+		"worker_panics":      int(s.workerPanics.Load()),
+		"expired_keys":       s.expiredKeys,
+		"lock_wait_avg_us":   lockWaitAvg,
+		"lock_wait_max_us":   lockWaitMax,
+		"notify_queue_depth": s.notifier.queueDepth(),
+		"notify_dropped":     int(s.notifier.dropped.Load()),
+		"event_subscribers":  s.events.count(),
+		"event_rejected":     int(s.events.rejected.Load()),
+		"event_slow_dropped": int(s.events.slowDropped.Load() + s.statsStream.slowDropped.Load()),
+		"stats_subscribers":  s.statsStream.count(),
+		"persist_retries":    int(s.persistStats.retries.Load()),
+		"persist_failures":   int(s.persistStats.failures.Load()),
+		"persist_degraded":   s.persistStats.degraded.Load(),
+		"persist_breaker":    breakerStateNames[s.breakerState()],
+		"snapshots_skipped":  int(s.snap.skipped.Load()),
+		"snapshot_timeouts":  int(s.snap.timeouts.Load()),
+		"in_flight":          int(s.concurrency.inFlight.Load()),
+		"waiting":            int(s.concurrency.waiting.Load()),
+		"coalesced_writes":   int(s.coalescedWrites.Load()),
+		"key_alert_level":    int(s.keyAlertLevel.Load()),
+		"client_disconnects": int(s.clientDisconnects.Load()),
+	}
+	s.mu.Unlock()
+	stats["status_codes"], stats["status_classes"] = s.metrics.statusCounts()
+	if s.replica != nil {
+		stats["replica"] = s.replica.stats()
+	}
+	if s.connLimit != nil {
+		stats["conns_rejected_per_ip"] = int(s.connLimit.rejected.Load())
+	}
+	if s.webhooks != nil {
+		stats["webhooks"] = map[string]int{
+			"sent":     int(s.webhooks.sent.Load()),
+			"failures": int(s.webhooks.failures.Load()),
+			"dropped":  int(s.webhooks.dropped.Load()),
+			"queued":   len(s.webhooks.queue),
 		}
 	}
+	return stats
+}
+
+// newHTTPServer applies the connection settings: -idle-timeout and
+// -keep-alives decide how long and whether connections are reused between
+// requests.
+func newHTTPServer(cfg *Config, handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:        cfg.Addr,
+		Handler:     handler,
+		IdleTimeout: cfg.IdleTimeout,
+		ReadTimeout: cfg.ReadTimeout,
+	}
+	srv.SetKeepAlivesEnabled(cfg.KeepAlives)
+	return srv
 }
 
 func main() {
-	server := NewServer()
-	mux := http.NewServeMux()
+	cfg, err := loadConfig()
+	if err != nil {
+		logger.Println("Config error:", err)
+		os.Exit(2)
+	}
+	logs, err := setupLogOutput(cfg)
+	if err != nil {
+		logger.Println("Log output error:", err)
+		os.Exit(2)
+	}
+	// exit closes the log file before an early exit.
+	exit := func(code int) {
+		logs.close()
+		os.Exit(code)
+	}
+	server := NewServer(cfg)
 
-	mux.Handle("/public/", http.StripPrefix("/public/", http.FileServer(http.Dir("public"))))
+	if !cfg.APIOnly {
+		server.statsTmpl, err = parseStatsPage("views/stats.html")
+		if err != nil {
+			logger.Println("Failed to load templates:", err)
+			exit(1)
+		}
+	}
 
-	mux.HandleFunc("/api/data", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method == http.MethodPost {
-			server.postDataHandler(w, r)
-			return
+	if cfg.MessagesDir != "" {
+		server.messages, err = loadMessageCatalogs(cfg.MessagesDir)
+		if err != nil {
+			logger.Println("Failed to load message catalogs:", err)
+			exit(1)
 		}
-		if r.Method == http.MethodGet {
-			server.getDataHandler(w, r)
-			return
+		logger.Println("Error messages available in:", strings.Join(append([]string{"en"}, server.messages.languages()...), ", "))
+	}
+
+	// Building the routes fills server.reservedKeys, which the seed is
+	// validated against.
+	handler := server.routes()
+
+	n, err := server.loadSnapshot()
+	if err != nil {
+		logger.Println("Failed to load snapshot:", err)
+		exit(1)
+	}
+	if n > 0 {
+		logger.Printf("Loaded %d keys from %s\n", n, cfg.SnapshotPath)
+	}
+	if cfg.PullFrom != "" {
+		start := time.Now()
+		n, err := server.pullFrom(cfg.PullFrom)
+		switch {
+		case err == nil:
+			logger.Printf("Pulled %d keys from %s in %s\n", n, cfg.PullFrom, time.Since(start).Round(time.Millisecond))
+		case cfg.PullRequired:
+			logger.Printf("Failed to pull from %s: %v\n", cfg.PullFrom, err)
+			exit(1)
+		default:
+			logger.Printf("Warning: pull from %s failed: %v; serving local data\n", cfg.PullFrom, err)
 		}
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-	})
-	mux.HandleFunc("/api/data/", server.deleteDataHandler)
-	mux.HandleFunc("/api/stats", server.statsHandler)
-
-	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		switch r.URL.Path {
-		case "/", "/index":
-			http.ServeFile(w, r, "views/index.html")
-		case "/data":
-			http.ServeFile(w, r, "views/data.html")
-		case "/stats":
-			http.ServeFile(w, r, "views/stats.html")
+	}
+	if cfg.SeedFile != "" {
+		n, err := server.loadSeed(cfg.SeedFile)
+		switch {
+		case errors.Is(err, errSeedSkipped):
+			logger.Printf("Store not empty, skipping seed %s\n", cfg.SeedFile)
+		case err != nil:
+			logger.Println("Failed to load seed:", err)
+			exit(1)
 		default:
-			http.NotFound(w, r)
+			logger.Printf("Seeded %d keys from %s\n", n, cfg.SeedFile)
 		}
-	})
+	}
+	if cfg.SnapshotPath != "" {
+		server.OnShutdown("final snapshot", server.finalSnapshot)
+	}
 
-	srv := &http.Server{
-		Addr:    ":8080",
-		Handler: mux,
+	// Hooks run in reverse, so webhooks and replication drain after the
+	// notifier stops feeding them.
+	if server.webhooks != nil {
+		server.webhooks.start()
+		server.OnShutdown("webhooks", server.webhooks.close)
 	}
+	if server.replica != nil {
+		server.replica.start()
+		server.OnShutdown("replication", server.replica.close)
+	}
+	server.notifier.start()
+	server.OnShutdown("notifications", server.notifier.close)
+
+	srv := newHTTPServer(cfg, handler)
 
 	go server.startBackgroundWorker()
 
 	stop := make(chan os.Signal, 1)
 	signal.Notify(stop, os.Interrupt)
 
+	ln, err := net.Listen("tcp", cfg.Addr)
+	if err != nil {
+		logger.Println("Server error:", err)
+		exit(1)
+	}
+	if cfg.MaxConnsPerIP > 0 {
+		server.connLimit = newPerIPListener(ln, cfg.MaxConnsPerIP)
+		ln = server.connLimit
+	}
+
 	go func() {
-		fmt.Println("Server started at http://localhost:8080")
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			fmt.Println("Server error:", err)
+		logger.Println("Server started at", cfg.Addr)
+		if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+			logger.Println("Server error:", err)
 		}
 	}()
 
 	<-stop
-	fmt.Println("\nShutting down server...")
-	close(server.shutdownCh)
-
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-	_ = srv.Shutdown(ctx)
-
-	fmt.Println("Server exited properly")
+	logger.Println("\nShutting down server...")
+	server.shutdown(srv)
+	logger.Println("Server exited properly")
+	logs.close()
 }
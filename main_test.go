@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newTestServer builds a Server from command-line style args, always adding
+// -api-only, and serves its routes from an httptest server closed with the
+// test.
+func newTestServer(t testing.TB, args ...string) (*Server, *httptest.Server) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg, err := parseConfig(fs, append([]string{"-api-only"}, args...))
+	if err != nil {
+		t.Fatalf("parseConfig(%q): %v", args, err)
+	}
+	s := NewServer(cfg)
+	s.notifier.start()
+	ts := httptest.NewServer(s.routes())
+	t.Cleanup(func() {
+		ts.Close()
+		s.notifier.close()
+	})
+	return s, ts
+}
+
+// do sends a request with an optional JSON body and returns the response
+// with its body read.
+func do(t testing.TB, ts *httptest.Server, method, path, body string) (*http.Response, string) {
+	t.Helper()
+	return doWithHeader(t, ts, method, path, body, nil)
+}
+
+// doWithHeader is do with extra request headers.
+func doWithHeader(t testing.TB, ts *httptest.Server, method, path, body string, header http.Header) (*http.Response, string) {
+	t.Helper()
+	var r io.Reader
+	if body != "" {
+		r = strings.NewReader(body)
+	}
+	req, err := http.NewRequest(method, ts.URL+path, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	b, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(b)
+}
+
+// expect fails the test unless the request answers with status, and returns
+// the body.
+func expect(t testing.TB, ts *httptest.Server, method, path, body string, status int) string {
+	t.Helper()
+	resp, got := do(t, ts, method, path, body)
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s: status %d, want %d; body %s", method, path, resp.StatusCode, status, got)
+	}
+	return got
+}
+
+func decodeBody(t testing.TB, body string, v interface{}) {
+	t.Helper()
+	if err := json.Unmarshal([]byte(body), v); err != nil {
+		t.Fatalf("decode %q: %v", body, err)
+	}
+}
+
+func decodeJSON(t testing.TB, r io.Reader, v interface{}) {
+	t.Helper()
+	if err := json.NewDecoder(r).Decode(v); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+}
+
+// getValue reads one key through GET /api/data/{key}.
+func getValue(t testing.TB, ts *httptest.Server, key string) string {
+	t.Helper()
+	var got struct {
+		Value string `json:"value"`
+	}
+	decodeBody(t, expect(t, ts, http.MethodGet, "/api/data/"+key, "", http.StatusOK), &got)
+	return got.Value
+}
+
+func TestLargeIntegerRoundTrip(t *testing.T) {
+	const big = "9223372036854775807"
+
+	t.Run("use-number", func(t *testing.T) {
+		_, ts := newTestServer(t, "-use-number")
+		expect(t, ts, http.MethodPost, "/api/data", `{"max":`+big+`}`, http.StatusOK)
+		if got := getValue(t, ts, "max"); got != big {
+			t.Fatalf("stored %q, want %q", got, big)
+		}
+		expect(t, ts, http.MethodPut, "/api/data/put", `{"value":`+big+`}`, http.StatusOK)
+		if got := getValue(t, ts, "put"); got != big {
+			t.Fatalf("PUT stored %q, want %q", got, big)
+		}
+	})
+
+	t.Run("float64", func(t *testing.T) {
+		_, ts := newTestServer(t)
+		expect(t, ts, http.MethodPost, "/api/data", `{"max":`+big+`}`, http.StatusBadRequest)
+		expect(t, ts, http.MethodPut, "/api/data/put", `{"value":`+big+`}`, http.StatusBadRequest)
+		expect(t, ts, http.MethodGet, "/api/data/max", "", http.StatusNotFound)
+
+		expect(t, ts, http.MethodPost, "/api/data", `{"i":9007199254740992,"f":1.5,"e":1e2}`, http.StatusOK)
+		for key, want := range map[string]string{"i": "9007199254740992", "f": "1.5", "e": "100"} {
+			if got := getValue(t, ts, key); got != want {
+				t.Errorf("%s stored %q, want %q", key, got, want)
+			}
+		}
+	})
+}
+
+func TestDBBytesStaysConsistent(t *testing.T) {
+	s, ts := newTestServer(t)
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 500; i++ {
+		key := fmt.Sprintf("k%d", rng.Intn(40))
+		switch rng.Intn(3) {
+		case 0:
+			value := strings.Repeat("v", rng.Intn(50))
+			expect(t, ts, http.MethodPost, "/api/data", fmt.Sprintf(`{%q:%q}`, key, value), http.StatusOK)
+		case 1:
+			expect(t, ts, http.MethodPut, "/api/data/"+key, fmt.Sprintf(`{"value":%q}`, strings.Repeat("w", rng.Intn(50))), http.StatusOK)
+		case 2:
+			do(t, ts, http.MethodDelete, "/api/data/"+key, "")
+		}
+	}
+
+	s.mu.Lock()
+	want := 0
+	s.data.Range(func(k string, e entry) bool {
+		want += len(k) + len(e.Value)
+		return true
+	})
+	got := s.dataBytes
+	s.mu.Unlock()
+	if got != want {
+		t.Fatalf("running db_bytes %d, recomputed %d", got, want)
+	}
+	if got := s.stats()["db_bytes"]; got != want {
+		t.Fatalf("stats db_bytes %v, want %d", got, want)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// responseMetrics counts responses by exact status code.
+type responseMetrics struct {
+	mu       sync.Mutex
+	statuses map[int]int
+}
+
+func (m *responseMetrics) recordStatus(status int) {
+	m.mu.Lock()
+	if m.statuses == nil {
+		m.statuses = make(map[int]int)
+	}
+	m.statuses[status]++
+	m.mu.Unlock()
+}
+
+// statusCounts returns the per-code and per-class (2xx, 4xx, ...) counts.
+func (m *responseMetrics) statusCounts() (map[string]int, map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	codes := make(map[string]int, len(m.statuses))
+	classes := map[string]int{"2xx": 0, "3xx": 0, "4xx": 0, "5xx": 0}
+	for status, n := range m.statuses {
+		codes[fmt.Sprint(status)] = n
+		classes[fmt.Sprintf("%dxx", status/100)] += n
+	}
+	return codes, classes
+}
+
+func (m *responseMetrics) reset() {
+	m.mu.Lock()
+	m.statuses = nil
+	m.mu.Unlock()
+}
+
+// metricFamily names counters with their _total suffix, as both exposition
+// formats want it on samples; OpenMetrics drops it from the HELP and TYPE
+// lines.
+type metricFamily struct {
+	name    string
+	help    string
+	typ     string
+	unit    string
+	samples []metricSample
+}
+
+type metricSample struct {
+	labels string
+	value  float64
+}
+
+func gauge(name, help string, v float64) metricFamily {
+	return metricFamily{name: name, help: help, typ: "gauge", samples: []metricSample{{value: v}}}
+}
+
+func counter(name, help string, v float64) metricFamily {
+	return metricFamily{name: name, help: help, typ: "counter", samples: []metricSample{{value: v}}}
+}
+
+// withUnit sets the OpenMetrics UNIT of f, whose name must end in _unit.
+func withUnit(f metricFamily, unit string) metricFamily {
+	f.unit = unit
+	return f
+}
+
+func labeledCounter(name, help, label string, values map[string]int) metricFamily {
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	f := metricFamily{name: name, help: help, typ: "counter"}
+	for _, k := range keys {
+		f.samples = append(f.samples, metricSample{labels: fmt.Sprintf("%s=%q", label, k), value: float64(values[k])})
+	}
+	return f
+}
+
+func (s *Server) metricFamilies() []metricFamily {
+	s.mu.Lock()
+	requests, keys, bytes := s.requests, s.data.Len(), s.dataBytes
+	s.mu.Unlock()
+	codes, classes := s.metrics.statusCounts()
+
+	return []metricFamily{
+		counter("webserver_requests_total", "Requests counted by the API handlers.", float64(requests)),
+		gauge("webserver_db_keys", "Number of keys in the store.", float64(keys)),
+		withUnit(gauge("webserver_db_bytes", "Approximate size of keys and values in bytes.", float64(bytes)), "bytes"),
+		gauge("webserver_key_alert_level", "0 below -keys-warn, 1 at or above it, 2 at or above -keys-critical.", float64(s.keyAlertLevel.Load())),
+		gauge("webserver_persist_breaker_state", "Persistence breaker: 0 closed, 1 open (writes refused), 2 half-open.", float64(s.breakerState())),
+		gauge("webserver_in_flight_requests", "Requests currently being served.", float64(s.concurrency.inFlight.Load())),
+		gauge("webserver_waiting_requests", "Requests waiting for a -max-concurrent slot.", float64(s.concurrency.waiting.Load())),
+		labeledCounter("webserver_responses_total", "Responses by status code.", "code", codes),
+		labeledCounter("webserver_responses_class_total", "Responses by status class.", "class", classes),
+	}
+}
+
+// metricsHandler serves the classic Prometheus text format, or OpenMetrics
+// when the Accept header asks for application/openmetrics-text.
+func (s *Server) metricsHandler(w http.ResponseWriter, r *http.Request) {
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	var b strings.Builder
+	for _, f := range s.metricFamilies() {
+		if openMetrics {
+			name := f.name
+			if f.typ == "counter" {
+				name = strings.TrimSuffix(name, "_total")
+			}
+			fmt.Fprintf(&b, "# TYPE %s %s\n", name, f.typ)
+			if f.unit != "" {
+				fmt.Fprintf(&b, "# UNIT %s %s\n", name, f.unit)
+			}
+			fmt.Fprintf(&b, "# HELP %s %s\n", name, f.help)
+		} else {
+			fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s %s\n", f.name, f.help, f.name, f.typ)
+		}
+		for _, sm := range f.samples {
+			if sm.labels != "" {
+				fmt.Fprintf(&b, "%s{%s} %g\n", f.name, sm.labels, sm.value)
+			} else {
+				fmt.Fprintf(&b, "%s %g\n", f.name, sm.value)
+			}
+		}
+	}
+
+	w.Header().Set("Vary", "Accept")
+	if openMetrics {
+		b.WriteString("# EOF\n")
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+	w.Write([]byte(b.String()))
+}
+
+// resetStatsHandler zeroes the request counter and response metrics.
+func (s *Server) resetStatsHandler(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests = 0
+	s.statsRequests = 0
+	s.nsRequests = make(map[string]int)
+	s.mu.Unlock()
+	s.metrics.reset()
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
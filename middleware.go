@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// statusRecorder captures the status code written by a handler, and whether
+// writing the response failed.
+type statusRecorder struct {
+	http.ResponseWriter
+	status      int
+	writeFailed bool
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	if err != nil {
+		rec.writeFailed = true
+	}
+	return n, err
+}
+
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// logRequests records the arrival time and response status of every request
+// and, with -access-log, prints one line per request. A request whose client
+// went away before the response was written, seen as a canceled context or a
+// failed write, is counted and flagged client_disconnected; without the
+// access log -log-disconnects still prints a line for it.
+func (s *Server) logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		s.lastRequest.Store(start.UnixNano())
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r)
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		s.metrics.recordStatus(rec.status)
+		disconnected := rec.writeFailed || errors.Is(r.Context().Err(), context.Canceled)
+		if disconnected {
+			s.clientDisconnects.Add(1)
+		}
+		elapsed := time.Since(start).Round(time.Microsecond)
+		switch {
+		case s.cfg.AccessLog && disconnected:
+			logger.Printf("%s %s %d %s client_disconnected\n", r.Method, r.URL.RequestURI(), rec.status, elapsed)
+		case s.cfg.AccessLog:
+			logger.Printf("%s %s %d %s\n", r.Method, r.URL.RequestURI(), rec.status, elapsed)
+		case disconnected && s.cfg.LogDisconnects:
+			logger.Printf("INFO: client disconnected during %s %s after %s\n", r.Method, r.URL.RequestURI(), elapsed)
+		}
+	})
+}
+
+// allowMethods rejects any method outside -allowed-methods with 405 before
+// the request reaches the router. OPTIONS always passes, so preflights and
+// Allow queries keep working; the Allow header lists what the path answers
+// to within the allowlist.
+func (s *Server) allowMethods(rt *router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodOptions && !rt.allowed[r.Method] {
+			allow := rt.allowedMethods(r.URL.Path)
+			if allow == nil {
+				allow = s.cfg.AllowedMethods
+			}
+			w.Header().Set("Allow", strings.Join(allow, ", "))
+			writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// concurrencyGauge tracks requests being served and, with -max-concurrent,
+// those waiting for a free slot.
+type concurrencyGauge struct {
+	sem      chan struct{}
+	inFlight atomic.Int64
+	waiting  atomic.Int64
+}
+
+// streamingPaths hold their connection open indefinitely, so they are
+// counted as in flight but never take a -max-concurrent slot.
+var streamingPaths = map[string]bool{
+	"/api/events":       true,
+	"/api/stats/stream": true,
+}
+
+func (s *Server) trackConcurrency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		g := &s.concurrency
+		if g.sem != nil && !streamingPaths[r.URL.Path] {
+			g.waiting.Add(1)
+			select {
+			case g.sem <- struct{}{}:
+				g.waiting.Add(-1)
+				defer func() { <-g.sem }()
+			case <-r.Context().Done():
+				g.waiting.Add(-1)
+				return
+			}
+		}
+
+		g.inFlight.Add(1)
+		defer g.inFlight.Add(-1)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// methodOverride lets clients limited to GET and POST reach PUT and DELETE
+// routes: a POST carrying X-HTTP-Method-Override or ?_method= is routed as
+// that method when it is listed in -method-overrides. Overrides are only
+// honoured on POST. A GET must stay safe to prefetch, cache and follow from
+// a link, so it can never be turned into a write; the write itself still
+// passes through -allowed-methods and read-only mode as the overridden
+// method.
+func (s *Server) methodOverride(next http.Handler) http.Handler {
+	allowed := make(map[string]bool, len(s.cfg.MethodOverrides))
+	for _, m := range s.cfg.MethodOverrides {
+		allowed[m] = true
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m := r.Header.Get("X-HTTP-Method-Override")
+		if m == "" {
+			m = r.URL.Query().Get("_method")
+		}
+		if m == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		m = strings.ToUpper(m)
+		if !allowed[m] {
+			writeError(w, http.StatusBadRequest, codeInvalidParameter, fmt.Sprintf("Method override to %s is not allowed", m))
+			return
+		}
+		r = r.WithContext(r.Context())
+		r.Method = m
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAllowedMethodsKeepsOptions(t *testing.T) {
+	_, ts := newTestServer(t, "-allowed-methods", "GET,POST")
+
+	resp, _ := do(t, ts, http.MethodOptions, "/api/data", "")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("OPTIONS status %d, want 204", resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("Allow"), "GET, OPTIONS, POST"; got != want {
+		t.Fatalf("OPTIONS Allow %q, want %q", got, want)
+	}
+
+	resp, _ = do(t, ts, http.MethodDelete, "/api/data/k", "")
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("DELETE status %d, want 405", resp.StatusCode)
+	}
+	if got, want := resp.Header.Get("Allow"), "GET, OPTIONS"; got != want {
+		t.Fatalf("DELETE Allow %q, want %q", got, want)
+	}
+}
+
+func TestConcurrencyGauges(t *testing.T) {
+	s, _ := newTestServer(t, "-max-concurrent", "1")
+	release := make(chan struct{})
+	ts := httptest.NewServer(s.trackConcurrency(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	})))
+	defer ts.Close()
+
+	gauges := func() (int, int) {
+		st := s.stats()
+		return st["in_flight"].(int), st["waiting"].(int)
+	}
+	waitFor := func(inFlight, waiting int) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			f, w := gauges()
+			if f == inFlight && w == waiting {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("in_flight %d waiting %d, want %d and %d", f, w, inFlight, waiting)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := ts.Client().Get(ts.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			resp.Body.Close()
+		}()
+	}
+	waitFor(1, 2)
+
+	close(release)
+	wg.Wait()
+	waitFor(0, 0)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Keys written as "<namespace><separator><rest>" belong to that namespace;
+// everything else, and every key when -namespace-separator is empty, falls in
+// the default namespace.
+const defaultNamespace = "default"
+
+// NamespaceQuota caps one namespace. The -namespace-quotas flag takes a
+// comma-separated list of
+//
+//	NAMESPACE=KEYS/BYTES
+//
+// where 0 leaves that dimension unlimited and the namespace * applies to
+// every namespace without a rule of its own. Writes growing a namespace past
+// its quota get 507 even when -max-bytes has room left.
+//
+//	-namespace-quotas 'acme=1000/1048576,*=100/65536'
+type NamespaceQuota struct {
+	MaxKeys  int `json:"max_keys"`
+	MaxBytes int `json:"max_bytes"`
+}
+
+func parseNamespaceQuotas(spec string) (map[string]NamespaceQuota, error) {
+	quotas := make(map[string]NamespaceQuota)
+	for _, item := range splitList(spec) {
+		ns, limit, ok := strings.Cut(item, "=")
+		keys, bytes, ok2 := strings.Cut(limit, "/")
+		if !ok || !ok2 || strings.TrimSpace(ns) == "" {
+			return nil, fmt.Errorf("quota %q: want NAMESPACE=KEYS/BYTES", item)
+		}
+		var q NamespaceQuota
+		var err1, err2 error
+		q.MaxKeys, err1 = strconv.Atoi(strings.TrimSpace(keys))
+		q.MaxBytes, err2 = strconv.Atoi(strings.TrimSpace(bytes))
+		if err1 != nil || err2 != nil || q.MaxKeys < 0 || q.MaxBytes < 0 {
+			return nil, fmt.Errorf("quota %q: KEYS and BYTES must be non-negative integers", item)
+		}
+		quotas[strings.TrimSpace(ns)] = q
+	}
+	return quotas, nil
+}
+
+func (s *Server) quotaFor(ns string) (NamespaceQuota, bool) {
+	if q, ok := s.cfg.NamespaceQuotas[ns]; ok {
+		return q, true
+	}
+	q, ok := s.cfg.NamespaceQuotas["*"]
+	return q, ok
+}
+
+// namespaceUsage is kept up to date by setLocked and deleteLocked, so quota
+// checks never have to scan the store.
+type namespaceUsage struct {
+	keys     int
+	bytes    int
+	rejected int
+}
+
+type namespaceStats struct {
+	DBSize   int `json:"db_size"`
+	DBBytes  int `json:"db_bytes"`
+	Requests int `json:"requests"`
+
+	MaxKeys       int `json:"max_keys,omitempty"`
+	MaxBytes      int `json:"max_bytes,omitempty"`
+	QuotaRejected int `json:"quota_rejected,omitempty"`
+}
+
+func (s *Server) namespaceOf(key string) string {
+	if s.cfg.NamespaceSeparator == "" {
+		return defaultNamespace
+	}
+	ns, _, ok := strings.Cut(key, s.cfg.NamespaceSeparator)
+	if !ok || ns == "" {
+		return defaultNamespace
+	}
+	return ns
+}
+
+// usageLocked returns the usage of ns, creating it if needed. The caller must
+// hold s.mu.
+func (s *Server) usageLocked(ns string) *namespaceUsage {
+	u, ok := s.nsUsage[ns]
+	if !ok {
+		u = &namespaceUsage{}
+		s.nsUsage[ns] = u
+	}
+	return u
+}
+
+// accountLocked moves the usage of key's namespace by the given deltas. The
+// caller must hold s.mu.
+func (s *Server) accountLocked(key string, keys, bytes int) {
+	ns := s.namespaceOf(key)
+	u := s.usageLocked(ns)
+	u.keys += keys
+	u.bytes += bytes
+	if u.keys == 0 && u.bytes == 0 && u.rejected == 0 {
+		delete(s.nsUsage, ns)
+	}
+}
+
+// recountLocked rebuilds dataBytes and the namespace usage from the store
+// and the lists, for when they were replaced wholesale. The caller must hold
+// s.lists.mu and s.mu.
+func (s *Server) recountLocked() {
+	s.dataBytes = 0
+	s.nsUsage = make(map[string]*namespaceUsage)
+	s.data.Range(func(k string, e entry) bool {
+		s.dataBytes += len(k) + len(e.Value)
+		s.accountLocked(k, 1, len(k)+len(e.Value))
+		return true
+	})
+	for k, list := range s.lists.lists {
+		n := listBytes(k, list)
+		s.dataBytes += n
+		s.accountLocked(k, 1, n)
+	}
+}
+
+// namespaceBreakdown reports size, request counts and quotas per namespace.
+func (s *Server) namespaceBreakdown() map[string]*namespaceStats {
+	out := make(map[string]*namespaceStats)
+	get := func(ns string) *namespaceStats {
+		st, ok := out[ns]
+		if !ok {
+			st = &namespaceStats{}
+			if q, ok := s.quotaFor(ns); ok {
+				st.MaxKeys, st.MaxBytes = q.MaxKeys, q.MaxBytes
+			}
+			out[ns] = st
+		}
+		return st
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for ns, u := range s.nsUsage {
+		st := get(ns)
+		st.DBSize, st.DBBytes, st.QuotaRejected = u.keys, u.bytes, u.rejected
+	}
+	for ns, n := range s.nsRequests {
+		get(ns).Requests = n
+	}
+	for ns := range s.cfg.NamespaceQuotas {
+		if ns != "*" {
+			get(ns)
+		}
+	}
+	return out
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// changeEvent is one change to the data: "set" carries the new Value,
+// "delete" and "expire" only the key, and "ttl" the new ExpiresAt, or none
+// when the key was made persistent. "push" carries the Values appended to a
+// list and "pop" only its key. "restore" has no key: the whole dataset was
+// replaced from a backup.
+type changeEvent struct {
+	Op        string     `json:"op"`
+	Key       string     `json:"key,omitempty"`
+	Value     string     `json:"value,omitempty"`
+	Values    []string   `json:"values,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	Time      time.Time  `json:"time"`
+}
+
+// notifier delivers change events to its sinks from a fixed pool of workers
+// fed by a bounded queue. When the queue is full it drops either the incoming
+// event or the oldest queued one, so publishing never blocks a request.
+//
+// The pool does not keep events in order. Sinks that need publish order are
+// added with addOrderedSink and called inline by publish, so they must only
+// queue and never block. Writers publish while still holding s.mu, so
+// ordered sinks see changes to a key in the order they were committed.
+type notifier struct {
+	queue      chan changeEvent
+	workers    int
+	dropOldest bool
+
+	mu      sync.Mutex
+	sinks   []func(changeEvent)
+	ordered []func(changeEvent)
+	closed  bool
+
+	dropped atomic.Int64
+	wg      sync.WaitGroup
+}
+
+func newNotifier(workers, queueSize int, dropOldest bool) *notifier {
+	return &notifier{
+		queue:      make(chan changeEvent, queueSize),
+		workers:    workers,
+		dropOldest: dropOldest,
+	}
+}
+
+func (n *notifier) addSink(fn func(changeEvent)) {
+	n.mu.Lock()
+	n.sinks = append(n.sinks, fn)
+	n.mu.Unlock()
+}
+
+func (n *notifier) addOrderedSink(fn func(changeEvent)) {
+	n.mu.Lock()
+	n.ordered = append(n.ordered, fn)
+	n.mu.Unlock()
+}
+
+func (n *notifier) start() {
+	for i := 0; i < n.workers; i++ {
+		n.wg.Add(1)
+		go n.run()
+	}
+}
+
+func (n *notifier) run() {
+	defer n.wg.Done()
+	for ev := range n.queue {
+		n.mu.Lock()
+		sinks := n.sinks
+		n.mu.Unlock()
+		for _, sink := range sinks {
+			sink(ev)
+		}
+	}
+}
+
+func (n *notifier) publish(ev changeEvent) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.closed {
+		return
+	}
+	for _, sink := range n.ordered {
+		sink(ev)
+	}
+	if len(n.sinks) == 0 {
+		return
+	}
+
+	select {
+	case n.queue <- ev:
+		return
+	default:
+	}
+	if n.dropOldest {
+		select {
+		case <-n.queue:
+			n.dropped.Add(1)
+		default:
+		}
+		select {
+		case n.queue <- ev:
+			return
+		default:
+		}
+	}
+	n.dropped.Add(1)
+}
+
+// close stops accepting events and waits for queued ones to be delivered.
+func (n *notifier) close() error {
+	n.mu.Lock()
+	if !n.closed {
+		n.closed = true
+		close(n.queue)
+	}
+	n.mu.Unlock()
+	n.wg.Wait()
+	return nil
+}
+
+func (n *notifier) queueDepth() int {
+	return len(n.queue)
+}
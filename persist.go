@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type persistStats struct {
+	retries  atomic.Int64
+	failures atomic.Int64
+	degraded atomic.Bool
+}
+
+// persist runs a persistence operation, retrying transient failures with
+// exponential backoff. Persistence is only marked degraded once every attempt
+// has failed; the next success clears it. The outcome feeds the breaker.
+func (s *Server) persist(op string, fn func() error) error {
+	err := s.persistWithRetries(op, fn)
+	s.breakerRecord(err)
+	return err
+}
+
+func (s *Server) persistWithRetries(op string, fn func() error) error {
+	backoff := s.cfg.PersistBackoff
+	var err error
+	for attempt := 0; ; attempt++ {
+		if err = fn(); err == nil {
+			if s.persistStats.degraded.Swap(false) {
+				logger.Printf("Persistence recovered after %s\n", op)
+			}
+			return nil
+		}
+		if attempt >= s.cfg.PersistRetries || errors.Is(err, errSnapshotTimeout) {
+			break
+		}
+		s.persistStats.retries.Add(1)
+		logger.Printf("Persistence: %s failed (attempt %d/%d), retrying in %s: %v\n", op, attempt+1, s.cfg.PersistRetries+1, backoff, err)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+
+	s.persistStats.failures.Add(1)
+	if !s.persistStats.degraded.Swap(true) {
+		logger.Printf("Persistence degraded: %s failed after %d attempts: %v\n", op, s.cfg.PersistRetries+1, err)
+	}
+	return err
+}
+
+// Persistence circuit breaker states.
+const (
+	breakerClosed = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+var breakerStateNames = [...]string{"closed", "open", "half_open"}
+
+// persistBreaker stops accepting writes while persistence keeps failing.
+// After -breaker-failures consecutive failed persist calls it opens and
+// writes get 503 for -breaker-cooldown. It then half-opens: writes are
+// accepted again and the next persist call decides, closing the breaker on
+// success and reopening it on failure.
+type persistBreaker struct {
+	mu          sync.Mutex
+	state       int
+	consecutive int
+	openedAt    time.Time
+}
+
+// allowWrite reports whether the breaker lets a write through, or how long
+// until it half-opens.
+func (s *Server) breakerAllowWrite(now time.Time) (bool, time.Duration) {
+	b := &s.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != breakerOpen {
+		return true, 0
+	}
+	if wait := s.cfg.BreakerCooldown - now.Sub(b.openedAt); wait > 0 {
+		return false, wait
+	}
+	b.state = breakerHalfOpen
+	logger.Println("Persistence breaker half-open: accepting writes until the next persist attempt")
+	return true, 0
+}
+
+func (s *Server) breakerRecord(err error) {
+	if s.cfg.BreakerFailures <= 0 {
+		return
+	}
+	b := &s.breaker
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		if b.state != breakerClosed {
+			logger.Println("Persistence breaker closed")
+		}
+		b.state, b.consecutive = breakerClosed, 0
+		return
+	}
+	b.consecutive++
+	if b.state == breakerHalfOpen || (b.state == breakerClosed && b.consecutive >= s.cfg.BreakerFailures) {
+		b.state, b.openedAt = breakerOpen, time.Now()
+		logger.Printf("Persistence breaker open after %d consecutive failures: refusing writes for %s\n", b.consecutive, s.cfg.BreakerCooldown)
+	}
+}
+
+func (s *Server) breakerState() int {
+	s.breaker.mu.Lock()
+	defer s.breaker.mu.Unlock()
+	return s.breaker.state
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestPersistBreakerTransitions(t *testing.T) {
+	s, ts := newTestServer(t, "-breaker-failures", "2", "-breaker-cooldown", "50ms", "-persist-retries", "0")
+	fail := func() error { return errors.New("disk full") }
+	ok := func() error { return nil }
+
+	state := func(want string) {
+		t.Helper()
+		if got := breakerStateNames[s.breakerState()]; got != want {
+			t.Fatalf("breaker %s, want %s", got, want)
+		}
+		if got := s.stats()["persist_breaker"]; got != want {
+			t.Fatalf("stats persist_breaker %v, want %s", got, want)
+		}
+	}
+	refused := func() {
+		t.Helper()
+		resp, body := do(t, ts, http.MethodPost, "/api/data", `{"k":"v"}`)
+		var got apiError
+		decodeBody(t, body, &got)
+		if resp.StatusCode != http.StatusServiceUnavailable || got.Code != codePersistenceUnavailable || resp.Header.Get("Retry-After") == "" {
+			t.Fatalf("write while open: %d %q Retry-After %q", resp.StatusCode, got.Code, resp.Header.Get("Retry-After"))
+		}
+	}
+	accepted := func() {
+		t.Helper()
+		expect(t, ts, http.MethodPost, "/api/data", `{"k":"v"}`, http.StatusOK)
+	}
+
+	s.persist("test", fail)
+	state("closed")
+	accepted()
+
+	s.persist("test", fail)
+	state("open")
+	refused()
+
+	time.Sleep(60 * time.Millisecond)
+	accepted()
+	state("half_open")
+
+	// A failure while half-open reopens at once, without counting up again.
+	s.persist("test", fail)
+	state("open")
+	refused()
+
+	time.Sleep(60 * time.Millisecond)
+	accepted()
+	state("half_open")
+	s.persist("test", ok)
+	state("closed")
+
+	// Closing resets the count: one more failure does not reopen.
+	s.persist("test", fail)
+	state("closed")
+	accepted()
+}
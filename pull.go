@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// pullFrom copies the whole dataset of the peer at base into the store from
+// its GET /api/export stream, lists included, and returns how many keys it
+// copied. Keys keep the peer's modification time and expiry. Nothing is
+// applied unless the stream matches the X-Export-Count and X-Export-Sha256
+// trailers, so a truncated transfer leaves the store as it was. Pulled keys overwrite local
+// ones, including any loaded from the snapshot, since the peer is assumed to
+// be current.
+//
+// main runs it before the listener starts, so the instance does not serve
+// until it has caught up.
+func (s *Server) pullFrom(base string) (int, error) {
+	client := &http.Client{Timeout: 5 * time.Minute}
+	req, err := http.NewRequest(http.MethodGet, base+"/api/export", nil)
+	if err != nil {
+		return 0, err
+	}
+	// The checksum covers the body before any Content-Encoding.
+	req.Header.Set("Accept-Encoding", "identity")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("peer answered %s", resp.Status)
+	}
+
+	sum := sha256.New()
+	br := bufio.NewReader(io.TeeReader(resp.Body, sum))
+	var records []exportRecord
+	for {
+		line, err := br.ReadBytes('\n')
+		if len(line) > 0 {
+			var rec exportRecord
+			if err := json.Unmarshal(line, &rec); err != nil {
+				return 0, fmt.Errorf("export record %d: %w", len(records)+1, err)
+			}
+			records = append(records, rec)
+		}
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	count, digest := resp.Trailer.Get(trailerExportCount), resp.Trailer.Get(trailerExportSHA256)
+	if count == "" || digest == "" {
+		return 0, fmt.Errorf("peer sent no %s and %s trailers", trailerExportCount, trailerExportSHA256)
+	}
+	if n, err := strconv.Atoi(count); err != nil || n != len(records) {
+		return 0, fmt.Errorf("export truncated: got %d records, peer sent %s", len(records), count)
+	}
+	if got := hex.EncodeToString(sum.Sum(nil)); got != digest {
+		return 0, fmt.Errorf("export checksum mismatch: got %s, peer sent %s", got, digest)
+	}
+
+	now := time.Now()
+	n := 0
+	s.lists.mu.Lock()
+	s.mu.Lock()
+	for _, rec := range records {
+		if rec.List != nil {
+			s.setListLocked(rec.Key, rec.List)
+			n++
+			continue
+		}
+		e := backupEntry{Value: rec.Value, ExpiresAt: rec.ExpiresAt}.entry()
+		if rec.Modified != nil {
+			e.Modified = *rec.Modified
+		}
+		if e.expired(now) {
+			continue
+		}
+		s.setEntryLocked(rec.Key, e)
+		n++
+	}
+	s.mu.Unlock()
+	s.lists.mu.Unlock()
+	return n, nil
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPullFromKeepsExpiryAndModified(t *testing.T) {
+	peer, peerTS := newTestServer(t)
+	expect(t, peerTS, http.MethodPost, "/api/data?ttl=3600", `{"expiring":"1"}`, http.StatusOK)
+	expect(t, peerTS, http.MethodPost, "/api/data", `{"kept":"2"}`, http.StatusOK)
+
+	s, _ := newTestServer(t)
+	n, err := s.pullFrom(peerTS.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Fatalf("pulled %d keys, want 2", n)
+	}
+
+	for _, k := range []string{"expiring", "kept"} {
+		want, _ := peer.data.Get(k)
+		got, ok := s.data.Get(k)
+		if !ok {
+			t.Fatalf("%s not pulled", k)
+		}
+		if got.Value != want.Value || !got.Modified.Equal(want.Modified) || !got.ExpiresAt.Equal(want.ExpiresAt) {
+			t.Errorf("%s = %+v, want %+v", k, got, want)
+		}
+	}
+	if e, _ := s.data.Get("expiring"); e.ExpiresAt.IsZero() {
+		t.Error("expiring key lost its TTL")
+	}
+}
+
+func TestPullFromRejectsTruncatedExport(t *testing.T) {
+	peer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", trailerExportCount+", "+trailerExportSHA256)
+		w.Write([]byte(`{"key":"a","value":"1","modified":"2024-01-01T00:00:00Z"}` + "\n"))
+		w.Header().Set(trailerExportCount, "2")
+		w.Header().Set(trailerExportSHA256, "00")
+	}))
+	defer peer.Close()
+
+	s, _ := newTestServer(t)
+	if _, err := s.pullFrom(peer.URL); err == nil {
+		t.Fatal("pull of a truncated export succeeded")
+	}
+	if s.data.Len() != 0 {
+		t.Fatalf("truncated pull applied %d keys", s.data.Len())
+	}
+}
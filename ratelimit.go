@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RateLimit is one -rate-limits rule. The flag takes a comma-separated list
+// of rules shaped
+//
+//	[METHOD ]PATTERN=COUNT/UNIT[:BURST]
+//
+// where PATTERN is a route pattern exactly as registered (for example
+// /api/data/{key}) or * for every route, UNIT is s, m or h, and BURST
+// defaults to COUNT. Each route takes the first rule matching its method and
+// pattern; routes sharing a rule share its budget. Budgets are per client IP.
+//
+//	-rate-limits 'POST /api/data=10/s:20,GET /api/data/{key}=200/s,*=50/s'
+type RateLimit struct {
+	Method  string  `json:"method,omitempty"`
+	Pattern string  `json:"pattern"`
+	Rate    float64 `json:"rate_per_second"`
+	Burst   int     `json:"burst"`
+}
+
+func parseRateLimits(spec string) ([]RateLimit, error) {
+	var rules []RateLimit
+	for _, item := range splitList(spec) {
+		route, limit, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("rule %q: want [METHOD ]PATTERN=COUNT/UNIT[:BURST]", item)
+		}
+
+		var rule RateLimit
+		fields := strings.Fields(route)
+		switch len(fields) {
+		case 1:
+			rule.Pattern = fields[0]
+		case 2:
+			rule.Method, rule.Pattern = strings.ToUpper(fields[0]), fields[1]
+		default:
+			return nil, fmt.Errorf("rule %q: want [METHOD ]PATTERN before =", item)
+		}
+
+		limit, burst, hasBurst := strings.Cut(strings.TrimSpace(limit), ":")
+		count, unit, ok := strings.Cut(limit, "/")
+		n, err := strconv.Atoi(count)
+		if !ok || err != nil || n <= 0 {
+			return nil, fmt.Errorf("rule %q: count must be a positive integer followed by /s, /m or /h", item)
+		}
+		per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[unit]
+		if per == 0 {
+			return nil, fmt.Errorf("rule %q: unknown unit %q, want s, m or h", item, unit)
+		}
+		rule.Rate = float64(n) / per.Seconds()
+		rule.Burst = n
+		if hasBurst {
+			if rule.Burst, err = strconv.Atoi(burst); err != nil || rule.Burst <= 0 {
+				return nil, fmt.Errorf("rule %q: burst must be a positive integer", item)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+func (l RateLimit) matches(method, pattern string) bool {
+	return (l.Method == "" || l.Method == method) && (l.Pattern == "*" || l.Pattern == pattern)
+}
+
+// rateLimiter holds the token buckets of one rule, one per client IP.
+type rateLimiter struct {
+	rule    RateLimit
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// allow takes a token from client's bucket, or reports how long until one
+// is available.
+func (l *rateLimiter) allow(client string, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: float64(l.rule.Burst), last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(float64(l.rule.Burst), b.tokens+now.Sub(b.last).Seconds()*l.rule.Rate)
+	b.last = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / l.rule.Rate * float64(time.Second))
+}
+
+// sweep drops buckets that have refilled completely; they are equivalent to
+// a fresh bucket.
+func (l *rateLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rule.Rate >= float64(l.rule.Burst) {
+			delete(l.buckets, client)
+		}
+	}
+}
+
+// rateLimitRoute wraps a route's handler with the limiter of the first
+// -rate-limits rule matching it. It is installed as the router's wrap hook,
+// so the rule is chosen once per route rather than per request.
+func (s *Server) rateLimitRoute(method, pattern string, h http.HandlerFunc) http.HandlerFunc {
+	var limiter *rateLimiter
+	for i, rule := range s.cfg.RateLimits {
+		if rule.matches(method, pattern) {
+			limiter = s.limiters[i]
+			break
+		}
+	}
+	if limiter == nil {
+		return h
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		if ok, wait := limiter.allow(client, time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusTooManyRequests, codeRateLimited, "Rate limit exceeded")
+			return
+		}
+		h(w, r)
+	}
+}
+
+func (s *Server) sweepRateLimits() {
+	now := time.Now()
+	for _, l := range s.limiters {
+		l.sweep(now)
+	}
+}
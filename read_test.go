@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func fillKeys(s *Server, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := 0; i < n; i++ {
+		s.setLocked(fmt.Sprintf("key%d", i), "value", time.Time{})
+	}
+}
+
+func TestConcurrentFullReadsSeeWrites(t *testing.T) {
+	s, ts := newTestServer(t)
+	fillKeys(s, 1000)
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 32)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, bodies[i] = do(t, ts, http.MethodGet, "/api/data", "")
+		}(i)
+	}
+	wg.Wait()
+	for i, b := range bodies {
+		if b != bodies[0] {
+			t.Fatalf("reader %d saw a different map", i)
+		}
+	}
+
+	expect(t, ts, http.MethodPost, "/api/data", `{"late":"x"}`, http.StatusOK)
+	var all map[string]string
+	decodeBody(t, expect(t, ts, http.MethodGet, "/api/data", "", http.StatusOK), &all)
+	if all["late"] != "x" || len(all) != 1001 {
+		t.Fatalf("full read after a write has %d keys, late=%q", len(all), all["late"])
+	}
+}
+
+// BenchmarkFullReadHerd measures many clients dumping the same unchanged map
+// at once, the case the shared read computation is for: "direct" copies and
+// encodes the map per request as the handler did before, "singleflight" goes
+// through the handler.
+func BenchmarkFullReadHerd(b *testing.B) {
+	s, _ := newTestServer(b)
+	fillKeys(s, 10000)
+	h := s.routes()
+
+	b.Run("direct", func(b *testing.B) {
+		benchmarkHerd(b, func(w http.ResponseWriter) {
+			s.lock()
+			copyData := s.liveDataLocked(time.Now())
+			s.mu.Unlock()
+			body, err := json.Marshal(copyData)
+			if err != nil {
+				b.Fatal(err)
+			}
+			writeJSONBytes(w, http.StatusOK, body)
+		})
+	})
+	b.Run("singleflight", func(b *testing.B) {
+		benchmarkHerd(b, func(w http.ResponseWriter) {
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/data", nil))
+		})
+	})
+}
+
+func benchmarkHerd(b *testing.B, read func(w http.ResponseWriter)) {
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			w := httptest.NewRecorder()
+			read(w)
+			if w.Code != http.StatusOK {
+				b.Fatalf("status %d", w.Code)
+			}
+		}
+	})
+}
+
+func TestPagesWalkKeysInOrder(t *testing.T) {
+	s, ts := newTestServer(t)
+	fillKeys(s, 5)
+
+	for _, path := range []string{"/api/data?limit=2", "/api/data/metadata?limit=2"} {
+		var keys []string
+		cursor := ""
+		for {
+			var page struct {
+				Items      []struct{ Key string } `json:"items"`
+				NextCursor string                 `json:"next_cursor"`
+			}
+			decodeBody(t, expect(t, ts, http.MethodGet, path+"&cursor="+cursor, "", http.StatusOK), &page)
+			for _, it := range page.Items {
+				keys = append(keys, it.Key)
+			}
+			if cursor = page.NextCursor; cursor == "" {
+				break
+			}
+		}
+		if want := []string{"key0", "key1", "key2", "key3", "key4"}; !reflect.DeepEqual(keys, want) {
+			t.Fatalf("%s walked %q, want %q", path, keys, want)
+		}
+	}
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replicaAttempts is how often a change is sent to the replica before it is
+// given up, waiting replicaBackoff, then twice that, between attempts.
+const (
+	replicaAttempts = 5
+	replicaBackoff  = 250 * time.Millisecond
+)
+
+// replicator mirrors changes to the -replica-url instance: sets become PUT
+// /api/data/{key}, TTL changes PUT /api/data/{key}/ttl, deletes and expiries
+// DELETE /api/data/{key}, and list pushes and pops POST /api/list/{key}/push
+// and /pop on the replica. It is an ordered notifier sink feeding a single
+// worker, so changes reach the replica in the order they were committed.
+// Restoring a backup is refused while replicating, since the replica has no
+// way to apply it. Replication is still asynchronous, so the replica is only
+// eventually consistent:
+//
+//   - writes are acknowledged to clients before they reach the replica;
+//   - changes arriving while the -replica-queue is full are dropped and
+//     counted, and a change failing every attempt is given up;
+//   - the replica applies its own -default-ttl, since expiries travel as
+//     deletes rather than with the value.
+type replicator struct {
+	base   string
+	client *http.Client
+	queue  chan changeEvent
+
+	mu        sync.Mutex
+	closed    bool
+	lastError string
+	done      chan struct{}
+
+	sent     atomic.Int64
+	failures atomic.Int64
+	dropped  atomic.Int64
+	lagMs    atomic.Int64
+}
+
+func newReplicator(base string, queueSize int) *replicator {
+	return &replicator{
+		base:   base,
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan changeEvent, queueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// enqueue is the notifier sink.
+func (rp *replicator) enqueue(ev changeEvent) {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	if rp.closed {
+		return
+	}
+	select {
+	case rp.queue <- ev:
+	default:
+		rp.dropped.Add(1)
+	}
+}
+
+func (rp *replicator) start() {
+	go func() {
+		defer close(rp.done)
+		for ev := range rp.queue {
+			rp.apply(ev)
+		}
+	}()
+}
+
+func (rp *replicator) apply(ev changeEvent) {
+	var err error
+	backoff := replicaBackoff
+	for attempt := 1; attempt <= replicaAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = rp.send(ev); err == nil {
+			rp.sent.Add(1)
+			rp.lagMs.Store(time.Since(ev.Time).Milliseconds())
+			return
+		}
+	}
+	rp.failures.Add(1)
+	rp.mu.Lock()
+	rp.lastError = err.Error()
+	rp.mu.Unlock()
+	logger.Printf("Replication of %s %q failed after %d attempts: %v\n", ev.Op, ev.Key, replicaAttempts, err)
+}
+
+func (rp *replicator) send(ev changeEvent) error {
+	target := rp.base + "/api/data/" + url.PathEscape(ev.Key)
+	var req *http.Request
+	var err error
+	switch ev.Op {
+	case "set":
+		body, _ := json.Marshal(map[string]string{"value": ev.Value})
+		req, err = http.NewRequest(http.MethodPut, target, bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	case "delete", "expire":
+		req, err = http.NewRequest(http.MethodDelete, target, nil)
+	case "ttl":
+		ttl := -1
+		if ev.ExpiresAt != nil {
+			// An expiry already passed arrives as its own expire event.
+			if ttl = int(math.Ceil(time.Until(*ev.ExpiresAt).Seconds())); ttl <= 0 {
+				return nil
+			}
+		}
+		body, _ := json.Marshal(map[string]int{"ttl": ttl})
+		req, err = http.NewRequest(http.MethodPut, target+"/ttl", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	case "push":
+		body, _ := json.Marshal(map[string][]string{"values": ev.Values})
+		req, err = http.NewRequest(http.MethodPost, rp.listURL(ev.Key)+"/push", bytes.NewReader(body))
+		if err == nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+	case "pop":
+		req, err = http.NewRequest(http.MethodPost, rp.listURL(ev.Key)+"/pop", nil)
+	default:
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	resp, err := rp.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	// A key already gone from the replica is as good as deleted.
+	if resp.StatusCode == http.StatusNotFound && ev.Op != "set" && ev.Op != "push" {
+		return nil
+	}
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("replica answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (rp *replicator) listURL(key string) string {
+	return rp.base + "/api/list/" + url.PathEscape(key)
+}
+
+// close stops accepting changes and waits for queued ones to be sent.
+func (rp *replicator) close() error {
+	rp.mu.Lock()
+	if !rp.closed {
+		rp.closed = true
+		close(rp.queue)
+	}
+	rp.mu.Unlock()
+	<-rp.done
+	return nil
+}
+
+func (rp *replicator) stats() map[string]interface{} {
+	rp.mu.Lock()
+	lastError := rp.lastError
+	rp.mu.Unlock()
+	return map[string]interface{}{
+		"url":        stripUserinfo(rp.base),
+		"sent":       int(rp.sent.Load()),
+		"failures":   int(rp.failures.Load()),
+		"dropped":    int(rp.dropped.Load()),
+		"queued":     len(rp.queue),
+		"lag_ms":     int(rp.lagMs.Load()),
+		"last_error": lastError,
+	}
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+type paramsKey struct{}
+
+type route struct {
+	method   string
+	pattern  string
+	segments []string
+	handler  http.HandlerFunc
+	mutates  bool
+}
+
+// router is a small routing table matching (method, pattern) pairs. A
+// pattern segment of the form {name} matches any single non-empty segment
+// and a trailing * matches the rest of the path. Routes are tried in the
+// order they were registered, except that a route spelling a segment out
+// literally shadows routes capturing that segment as a parameter: with
+// /api/data/diff registered, "diff" is a reserved word that /api/data/{key}
+// never matches, whatever the method. In read-only mode routes registered
+// with handleWrite still match their path but answer 405, and so do routes
+// whose method is outside allowed (-allowed-methods) when it is set. OPTIONS
+// on any known path answers 204 with the path's Allow header.
+type router struct {
+	routes   []route
+	notFound http.HandlerFunc
+	readOnly bool
+	allowed  map[string]bool
+	// wrap, when set, decorates each handler as it is registered.
+	wrap func(method, pattern string, h http.HandlerFunc) http.HandlerFunc
+}
+
+func newRouter() *router {
+	return &router{notFound: jsonNotFound}
+}
+
+func (rt *router) handle(method, pattern string, h http.HandlerFunc) {
+	if rt.wrap != nil {
+		h = rt.wrap(method, pattern, h)
+	}
+	rt.routes = append(rt.routes, route{
+		method:   method,
+		pattern:  pattern,
+		segments: splitPath(pattern),
+		handler:  h,
+	})
+}
+
+func (rt *router) handleWrite(method, pattern string, h http.HandlerFunc) {
+	rt.handle(method, pattern, h)
+	rt.routes[len(rt.routes)-1].mutates = true
+}
+
+type matchedRoute struct {
+	route
+	params map[string]string
+}
+
+// matchPath returns the routes matching the path segments, keeping only
+// those with the fewest parameters so literal segments shadow {name} ones.
+func (rt *router) matchPath(segments []string) []matchedRoute {
+	var candidates []matchedRoute
+	fewest := -1
+	for _, rte := range rt.routes {
+		params, ok := rte.match(segments)
+		if !ok {
+			continue
+		}
+		if fewest < 0 || len(params) < fewest {
+			fewest = len(params)
+		}
+		candidates = append(candidates, matchedRoute{rte, params})
+	}
+	out := candidates[:0]
+	for _, c := range candidates {
+		if len(c.params) == fewest {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// serves reports whether the route is enabled, given read-only mode and
+// -allowed-methods.
+func (rt *router) serves(rte route) bool {
+	if rte.mutates && rt.readOnly {
+		return false
+	}
+	return rt.allowed == nil || rt.allowed[rte.method]
+}
+
+// allowedMethods lists the methods path answers to, sorted, with HEAD for
+// every GET and OPTIONS always; nil for an unknown path. It is the Allow
+// header of 405 and OPTIONS answers and the CORS Access-Control-Allow-Methods.
+func (rt *router) allowedMethods(path string) []string {
+	candidates := rt.matchPath(splitPath(path))
+	if len(candidates) == 0 {
+		return nil
+	}
+	allowed := []string{http.MethodOptions}
+	for _, c := range candidates {
+		if !rt.serves(c.route) {
+			continue
+		}
+		allowed = appendMethod(allowed, c.method)
+		if c.method == http.MethodGet && (rt.allowed == nil || rt.allowed[http.MethodHead]) {
+			allowed = appendMethod(allowed, http.MethodHead)
+		}
+	}
+	sort.Strings(allowed)
+	return allowed
+}
+
+func (rt *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	candidates := rt.matchPath(splitPath(r.URL.Path))
+	for _, c := range candidates {
+		if !rt.serves(c.route) {
+			continue
+		}
+		if c.method == r.Method || (r.Method == http.MethodHead && c.method == http.MethodGet) {
+			if len(c.params) > 0 {
+				r = r.WithContext(context.WithValue(r.Context(), paramsKey{}, c.params))
+			}
+			c.handler(w, r)
+			return
+		}
+	}
+
+	if len(candidates) > 0 {
+		w.Header().Set("Allow", strings.Join(rt.allowedMethods(r.URL.Path), ", "))
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeError(w, http.StatusMethodNotAllowed, codeMethodNotAllowed, "Method not allowed")
+		return
+	}
+	rt.notFound(w, r)
+}
+
+type endpoint struct {
+	Method string `json:"method"`
+	Path   string `json:"path"`
+}
+
+// endpoints lists the routes whose pattern starts with one of prefixes, in
+// registration order, leaving out write routes disabled by read-only mode.
+func (rt *router) endpoints(prefixes ...string) []endpoint {
+	var out []endpoint
+	for _, rte := range rt.routes {
+		if rte.mutates && rt.readOnly {
+			continue
+		}
+		for _, p := range prefixes {
+			if strings.HasPrefix(rte.pattern, p) {
+				out = append(out, endpoint{Method: rte.method, Path: rte.pattern})
+				break
+			}
+		}
+	}
+	return out
+}
+
+// reservedWords returns the literal segments that directly follow prefix in
+// registered patterns, such as "diff" for /api/data/diff under /api/data/.
+func (rt *router) reservedWords(prefix string) map[string]bool {
+	depth := len(splitPath(prefix))
+	words := make(map[string]bool)
+	for _, rte := range rt.routes {
+		if !strings.HasPrefix(rte.pattern, prefix) || len(rte.segments) <= depth {
+			continue
+		}
+		if seg := rte.segments[depth]; seg != "*" && !strings.HasPrefix(seg, "{") {
+			words[seg] = true
+		}
+	}
+	return words
+}
+
+func (rte route) match(segments []string) (map[string]string, bool) {
+	var params map[string]string
+	for i, seg := range rte.segments {
+		if seg == "*" {
+			return params, true
+		}
+		if i >= len(segments) {
+			return nil, false
+		}
+		if strings.HasPrefix(seg, "{") && strings.HasSuffix(seg, "}") {
+			if segments[i] == "" {
+				return nil, false
+			}
+			if params == nil {
+				params = make(map[string]string)
+			}
+			params[seg[1:len(seg)-1]] = segments[i]
+			continue
+		}
+		if seg != segments[i] {
+			return nil, false
+		}
+	}
+	return params, len(segments) == len(rte.segments)
+}
+
+func pathParam(r *http.Request, name string) string {
+	params, _ := r.Context().Value(paramsKey{}).(map[string]string)
+	return params[name]
+}
+
+func splitPath(p string) []string {
+	return strings.Split(strings.Trim(p, "/"), "/")
+}
+
+func appendMethod(methods []string, m string) []string {
+	for _, existing := range methods {
+		if existing == m {
+			return methods
+		}
+	}
+	return append(methods, m)
+}
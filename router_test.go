@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"testing"
+)
+
+// TestReservedKeysNeverReachKeyRoutes checks each literal route under
+// /api/data/ shadows /api/data/{key} for every method, and that its name
+// cannot be stored as a key.
+func TestReservedKeysNeverReachKeyRoutes(t *testing.T) {
+	s, ts := newTestServer(t)
+	var words []string
+	for w := range s.reservedKeys {
+		words = append(words, w)
+	}
+	sort.Strings(words)
+	for _, w := range []string{"diff", "metadata"} {
+		if !s.reservedKeys[w] {
+			t.Fatalf("reserved words %q lack %q", words, w)
+		}
+	}
+
+	for _, word := range words {
+		t.Run(word, func(t *testing.T) {
+			path := "/api/data/" + word
+			expect(t, ts, http.MethodPost, "/api/data", fmt.Sprintf(`{%q:"x"}`, word), http.StatusUnprocessableEntity)
+			for _, method := range []string{http.MethodPut, http.MethodDelete} {
+				resp, body := do(t, ts, method, path, `{"value":"x"}`)
+				if resp.StatusCode != http.StatusMethodNotAllowed || resp.Header.Get("Allow") == "" {
+					t.Errorf("%s %s: status %d Allow %q, want 405 with Allow; body %s",
+						method, path, resp.StatusCode, resp.Header.Get("Allow"), body)
+				}
+			}
+			resp, body := do(t, ts, http.MethodGet, path, "")
+			var got apiError
+			if resp.StatusCode != http.StatusOK {
+				decodeBody(t, body, &got)
+			}
+			if got.Code == codeKeyNotFound {
+				t.Errorf("GET %s reached the key route: %s", path, body)
+			}
+			if s.data.Len() != 0 {
+				t.Fatalf("%s was stored as a key", word)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import "net/http"
+
+func (s *Server) routes() http.Handler {
+	rt := newRouter()
+	rt.readOnly = s.cfg.ReadOnly
+	if len(s.cfg.AllowedMethods) > 0 {
+		rt.allowed = make(map[string]bool, len(s.cfg.AllowedMethods))
+		for _, m := range s.cfg.AllowedMethods {
+			rt.allowed[m] = true
+		}
+	}
+	if len(s.cfg.RateLimits) > 0 {
+		rt.wrap = s.rateLimitRoute
+	}
+
+	rt.handle(http.MethodGet, "/api/data", s.getDataHandler)
+	rt.handleWrite(http.MethodPost, "/api/data", s.guardWrite(s.postDataHandler))
+	rt.handle(http.MethodPost, "/api/data/diff", s.diffHandler)
+	rt.handle(http.MethodGet, "/api/data/metadata", s.metadataHandler)
+	rt.handle(http.MethodGet, "/api/data/{key}", s.getKeyHandler)
+	rt.handleWrite(http.MethodPut, "/api/data/{key}", s.guardWrite(s.putDataHandler))
+	rt.handleWrite(http.MethodDelete, "/api/data/{key}", s.guardWrite(s.deleteDataHandler))
+	rt.handleWrite(http.MethodPost, "/api/data/{key}/copyfrom/{src}", s.guardWrite(s.copyHandler))
+	rt.handleWrite(http.MethodPost, "/api/data/{key}/rename", s.guardWrite(s.renameHandler))
+	rt.handleWrite(http.MethodPost, "/api/data/{key}/getdel", s.guardWrite(s.getDelHandler))
+	rt.handle(http.MethodGet, "/api/data/{key}/ttl", s.getTTLHandler)
+	rt.handleWrite(http.MethodPut, "/api/data/{key}/ttl", s.guardWrite(s.putTTLHandler))
+	rt.handleWrite(http.MethodPost, "/api/data/{key}/expire", s.guardWrite(s.expireHandler))
+	rt.handle(http.MethodGet, "/api/export", s.exportHandler)
+	rt.handle(http.MethodGet, "/api/list/{key}", s.getListHandler)
+	rt.handleWrite(http.MethodPost, "/api/list/{key}/push", s.guardWrite(s.pushListHandler))
+	rt.handleWrite(http.MethodPost, "/api/list/{key}/pop", s.guardWrite(s.popListHandler))
+	rt.handle(http.MethodGet, "/api/blob/{key}", s.getBlobHandler)
+	rt.handleWrite(http.MethodPost, "/api/blob/{key}", s.guardWrite(s.putBlobChunkHandler))
+	rt.handleWrite(http.MethodPost, "/api/blob/{key}/commit", s.guardWrite(s.commitBlobHandler))
+	rt.handle(http.MethodGet, "/api/events", s.eventsHandler)
+	rt.handle(http.MethodGet, "/api/stats", s.statsHandler)
+	rt.handle(http.MethodGet, "/api/stats/stream", s.statsStreamHandler)
+	rt.handle(http.MethodPost, "/api/stats/reset", s.requireAdmin(s.resetStatsHandler))
+	rt.handle(http.MethodGet, "/metrics", s.metricsHandler)
+	rt.handle(http.MethodPost, "/api/admin/snapshot", s.requireAdmin(s.snapshotHandler))
+	rt.handle(http.MethodPost, "/api/admin/gc", s.requireAdmin(s.gcHandler))
+	rt.handle(http.MethodGet, "/api/admin/config", s.requireAdmin(s.configHandler))
+	rt.handle(http.MethodGet, "/api/admin/env", s.requireAdmin(s.envHandler))
+	rt.handle(http.MethodGet, "/api/admin/largest", s.requireAdmin(s.largestHandler))
+	rt.handle(http.MethodGet, "/api/admin/backup", s.requireAdmin(s.backupHandler))
+	rt.handleWrite(http.MethodPost, "/api/admin/restore", s.requireAdmin(s.guardWrite(s.restoreHandler)))
+
+	if !s.cfg.APIOnly {
+		rt.handle(http.MethodGet, "/public/*", staticHandler(s.cfg.StaticDir, s.cfg.StaticMaxAge))
+		rt.handle(http.MethodGet, s.cfg.FaviconPath, faviconHandler(s.cfg.FaviconFile))
+		rt.handle(http.MethodGet, s.cfg.RobotsPath, robotsHandler(s.cfg.RobotsFile))
+
+		rt.handle(http.MethodGet, "/", rootHandler(rt, s.cfg.IndexFile))
+		rt.handle(http.MethodGet, "/index", rootHandler(rt, s.cfg.IndexFile))
+		rt.handle(http.MethodGet, "/data", serveView("views/data.html"))
+		rt.handle(http.MethodGet, "/stats", s.statsPageHandler)
+	}
+
+	s.reservedKeys = rt.reservedWords("/api/data/")
+
+	var h http.Handler = s.responseOptions(rt)
+	if len(s.cfg.AllowedMethods) > 0 {
+		h = s.allowMethods(rt, h)
+	}
+	if len(s.cfg.MethodOverrides) > 0 {
+		h = s.methodOverride(h)
+	}
+	if len(s.cfg.CORSOrigins) > 0 {
+		h = s.corsMiddleware(rt, h)
+	}
+	if s.cfg.Gzip {
+		h = gzipMiddleware(s.cfg.GzipLevel, h)
+	}
+	if s.cfg.BufferResponses > 0 {
+		h = bufferResponses(s.cfg.BufferResponses, h)
+	}
+	return s.logRequests(s.trackConcurrency(h))
+}
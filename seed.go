@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// errSeedSkipped is returned by loadSeed when the store already has data.
+var errSeedSkipped = errors.New("store not empty")
+
+// loadSeed fills the store from the -seed file when, after the snapshot and
+// any -pull-from, it is still empty, and returns how many keys it wrote. The
+// file is never written back and never overwrites data: a store holding
+// even one key or list is left alone, with errSeedSkipped. It has the shape
+// of a POST /api/data body, and every entry must pass the same validation.
+func (s *Server) loadSeed(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var raw map[string]interface{}
+	if err := dec.Decode(&raw); err != nil {
+		return 0, fmt.Errorf("parse seed %s: %w", path, err)
+	}
+
+	var expiresAt time.Time
+	if s.cfg.DefaultTTL > 0 {
+		expiresAt = time.Now().Add(s.cfg.DefaultTTL)
+	}
+	payload, keys, verrs, _ := s.validatePayload(raw, expiresAt)
+	if len(verrs) > 0 {
+		return 0, fmt.Errorf("seed %s: key %q: %s", path, verrs[0].Key, verrs[0].Error)
+	}
+
+	s.lists.mu.Lock()
+	defer s.lists.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Len() > 0 || len(s.lists.lists) > 0 {
+		return 0, errSeedSkipped
+	}
+	for _, k := range keys {
+		s.setLocked(k, payload[k].value, payload[k].expiresAt)
+	}
+	return len(keys), nil
+}
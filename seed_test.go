@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSeedRejectsReservedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seed.json")
+	if err := os.WriteFile(path, []byte(`{"diff":"x"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestServer(t)
+	_, err := s.loadSeed(path)
+	if err == nil || !strings.Contains(err.Error(), "diff") {
+		t.Fatalf("loadSeed = %v, want an error naming the reserved key", err)
+	}
+	if s.data.Len() != 0 {
+		t.Fatalf("seed wrote %d keys", s.data.Len())
+	}
+}
+
+func TestSeedOnlyFillsEmptyStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seed.json")
+	if err := os.WriteFile(path, []byte(`{"a":"1","b":2}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, ts := newTestServer(t)
+	if n, err := s.loadSeed(path); err != nil || n != 2 {
+		t.Fatalf("loadSeed = %d, %v; want 2 keys", n, err)
+	}
+	if got := getValue(t, ts, "b"); got != "2" {
+		t.Fatalf("seeded b = %q, want %q", got, "2")
+	}
+
+	if err := os.WriteFile(path, []byte(`{"c":"3"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := s.loadSeed(path); !errors.Is(err, errSeedSkipped) || n != 0 {
+		t.Fatalf("second loadSeed = %d, %v; want it skipped", n, err)
+	}
+}
+
+func TestEmptySeedIsNotSkipped(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seed.json")
+	if err := os.WriteFile(path, []byte(`{}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, ts := newTestServer(t)
+	if n, err := s.loadSeed(path); err != nil || n != 0 {
+		t.Fatalf("loadSeed = %d, %v; want 0 keys and no error", n, err)
+	}
+
+	expect(t, ts, http.MethodPost, "/api/list/q/push", `{"value":"x"}`, http.StatusOK)
+	if _, err := s.loadSeed(path); !errors.Is(err, errSeedSkipped) {
+		t.Fatalf("loadSeed with a list stored = %v, want it skipped", err)
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type httpShutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// shutdown stops the server in a fixed order, each step completing before
+// the next starts:
+//
+//  1. refuse new writes and wait for in-flight ones;
+//  2. end event streams, which would otherwise hold up draining;
+//  3. stop accepting connections and drain in-flight requests;
+//  4. stop the background worker, letting its current tick finish;
+//  5. run the shutdown hooks, ending with the final snapshot.
+//
+// Steps 3 and 5 each get -shutdown-timeout.
+func (s *Server) shutdown(srv httpShutdowner) {
+	s.stopWrites()
+
+	close(s.streamsCh)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		logger.Println("Drain incomplete:", err)
+	}
+	if n := s.events.closedOnShutdown.Load() + s.statsStream.closedOnShutdown.Load(); n > 0 {
+		logger.Printf("Closed %d event streams\n", n)
+	}
+
+	close(s.shutdownCh)
+	<-s.workerDone
+
+	hooksCtx, hooksCancel := context.WithTimeout(context.Background(), s.cfg.ShutdownTimeout)
+	defer hooksCancel()
+	s.runShutdownHooks(hooksCtx)
+}
+
+type shutdownHook struct {
+	name string
+	fn   func() error
+}
+
+// OnShutdown registers fn to run during graceful shutdown. Hooks run in
+// reverse registration order, so resources are released before the things
+// they depend on.
+func (s *Server) OnShutdown(name string, fn func() error) {
+	s.hooksMu.Lock()
+	s.hooks = append(s.hooks, shutdownHook{name: name, fn: fn})
+	s.hooksMu.Unlock()
+}
+
+func (s *Server) runShutdownHooks(ctx context.Context) {
+	s.hooksMu.Lock()
+	hooks := make([]shutdownHook, len(s.hooks))
+	copy(hooks, s.hooks)
+	s.hooksMu.Unlock()
+
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		done := make(chan error, 1)
+		go func() { done <- h.fn() }()
+
+		select {
+		case err := <-done:
+			if err != nil {
+				logger.Printf("Shutdown hook %q failed: %v\n", h.name, err)
+			}
+		case <-ctx.Done():
+			logger.Printf("Shutdown deadline exceeded while running %q, skipping %d remaining hooks\n", h.name, i)
+			return
+		}
+	}
+}
+
+// guardWrite tracks in-flight writes so shutdown can wait for them. Once
+// stopWrites has been called new writes are refused with 503.
+func (s *Server) guardWrite(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.writesMu.RLock()
+		defer s.writesMu.RUnlock()
+		if s.writesStopped {
+			w.Header().Set("Retry-After", "5")
+			writeError(w, http.StatusServiceUnavailable, codeShuttingDown, "Server is shutting down")
+			return
+		}
+		if ok, wait := s.breakerAllowWrite(time.Now()); !ok {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, http.StatusServiceUnavailable, codePersistenceUnavailable, "Persistence is failing; writes are paused")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// stopWrites refuses further writes and blocks until in-flight writes have
+// completed, so a snapshot taken afterwards sees every acknowledged write.
+func (s *Server) stopWrites() {
+	s.writesMu.Lock()
+	s.writesStopped = true
+	s.writesMu.Unlock()
+}
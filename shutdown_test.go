@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestShutdownKeepsAcknowledgedWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.json")
+	s, ts := newTestServer(t, "-snapshot-path", path)
+	s.OnShutdown("final snapshot", s.finalSnapshot)
+	go s.startBackgroundWorker()
+
+	var (
+		mu    sync.Mutex
+		acked []string
+		wg    sync.WaitGroup
+	)
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				key := fmt.Sprintf("w%d-%d", w, i)
+				resp, err := ts.Client().Post(ts.URL+"/api/data", "application/json",
+					strings.NewReader(fmt.Sprintf(`{%q:"v"}`, key)))
+				if err != nil {
+					return
+				}
+				resp.Body.Close()
+				switch resp.StatusCode {
+				case http.StatusOK:
+					mu.Lock()
+					acked = append(acked, key)
+					mu.Unlock()
+				case http.StatusServiceUnavailable:
+					return
+				default:
+					t.Errorf("POST %s: status %d", key, resp.StatusCode)
+					return
+				}
+			}
+		}(w)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	s.shutdown(ts.Config)
+	wg.Wait()
+
+	if len(acked) == 0 {
+		t.Fatal("no writes were acknowledged before shutdown")
+	}
+	restored, _ := newTestServer(t, "-snapshot-path", path)
+	if _, err := restored.loadSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range acked {
+		if _, ok := restored.data.Get(key); !ok {
+			t.Errorf("acknowledged write %s missing from the final snapshot", key)
+		}
+	}
+}
+
+// orderRecorder stands in for the http.Server and records when Shutdown is
+// called relative to the other steps.
+type orderRecorder struct {
+	s     *Server
+	steps *[]string
+}
+
+func (o orderRecorder) Shutdown(ctx context.Context) error {
+	o.s.writesMu.RLock()
+	stopped := o.s.writesStopped
+	o.s.writesMu.RUnlock()
+	streamsClosed := false
+	select {
+	case <-o.s.streamsCh:
+		streamsClosed = true
+	default:
+	}
+	*o.steps = append(*o.steps, fmt.Sprintf("listener(writes stopped=%v streams closed=%v)", stopped, streamsClosed))
+	return nil
+}
+
+func TestShutdownOrder(t *testing.T) {
+	s, _ := newTestServer(t)
+	go s.startBackgroundWorker()
+
+	var steps []string
+	for _, name := range []string{"first", "second", "failing", "third"} {
+		name := name
+		s.OnShutdown(name, func() error {
+			select {
+			case <-s.workerDone:
+			default:
+				t.Errorf("hook %s ran before the worker stopped", name)
+			}
+			steps = append(steps, name)
+			if name == "failing" {
+				return errors.New("boom")
+			}
+			return nil
+		})
+	}
+
+	s.shutdown(orderRecorder{s: s, steps: &steps})
+
+	want := "listener(writes stopped=true streams closed=true),third,failing,second,first"
+	if got := strings.Join(steps, ","); got != want {
+		t.Fatalf("shutdown ran %s, want %s", got, want)
+	}
+}
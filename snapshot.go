@@ -0,0 +1,398 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	errSnapshotDisabled = errors.New("snapshots are disabled")
+	errSnapshotTimeout  = errors.New("snapshot timed out")
+)
+
+type snapshotTooSoonError struct {
+	retryAfter time.Duration
+}
+
+func (e *snapshotTooSoonError) Error() string {
+	return fmt.Sprintf("snapshot taken too recently, retry in %s", e.retryAfter.Round(time.Second))
+}
+
+// snapshotState makes sure only one snapshot runs at a time. Callers arriving
+// while a snapshot is in progress wait for it and share its result. A write
+// that outlives -snapshot-timeout is abandoned: its waiters get an error and
+// the call's abort flag tells the writer to drop its temp file instead of
+// renaming it over the snapshot. running stays set until the writer actually
+// returns, so a stuck write still blocks new periodic ones.
+type snapshotState struct {
+	mu       sync.Mutex
+	running  *snapshotCall
+	last     time.Time
+	skipped  atomic.Int64
+	timeouts atomic.Int64
+}
+
+type snapshotCall struct {
+	done  chan struct{}
+	err   error
+	abort atomic.Bool
+}
+
+func (s *Server) snapshot() error {
+	return s.runSnapshot("snapshot", false)
+}
+
+// finalSnapshot waits for any snapshot still running and then writes one
+// more regardless of -snapshot-min-interval, so the last writes before exit
+// reach disk.
+func (s *Server) finalSnapshot() error {
+	return s.runSnapshot("final snapshot", true)
+}
+
+func (s *Server) runSnapshot(op string, final bool) error {
+	c, err := s.startSnapshot(op, final)
+	if err != nil {
+		return err
+	}
+	return s.waitSnapshot(c)
+}
+
+// startSnapshot begins a snapshot in the background and returns its call. A
+// snapshot already running is shared rather than started again, except by
+// the final snapshot, which waits for it and then starts its own.
+func (s *Server) startSnapshot(op string, final bool) (*snapshotCall, error) {
+	if s.cfg.SnapshotPath == "" {
+		return nil, errSnapshotDisabled
+	}
+
+	s.snap.mu.Lock()
+	for c := s.snap.running; c != nil; c = s.snap.running {
+		s.snap.mu.Unlock()
+		if !final {
+			return c, nil
+		}
+		s.waitSnapshot(c)
+		s.snap.mu.Lock()
+		if s.snap.running == c {
+			// Timed out and aborted: it will not rename over ours.
+			break
+		}
+	}
+	if !final && !s.snap.last.IsZero() {
+		if wait := s.cfg.SnapshotMinInterval - time.Since(s.snap.last); wait > 0 {
+			s.snap.mu.Unlock()
+			return nil, &snapshotTooSoonError{retryAfter: wait}
+		}
+	}
+	c := &snapshotCall{done: make(chan struct{})}
+	s.snap.running = c
+	s.snap.mu.Unlock()
+
+	go s.writeSnapshotCall(op, c)
+	return c, nil
+}
+
+// writeSnapshotCall runs c to completion. A panic while snapshotting fails
+// the call instead of the process, as it would on the worker.
+func (s *Server) writeSnapshotCall(op string, c *snapshotCall) {
+	defer func() {
+		s.snap.mu.Lock()
+		if s.snap.running == c {
+			s.snap.running = nil
+		}
+		s.snap.last = time.Now()
+		s.snap.mu.Unlock()
+		close(c.done)
+	}()
+	defer func() {
+		if rec := recover(); rec != nil {
+			c.err = fmt.Errorf("%s panicked: %v", op, rec)
+			logger.Printf("Snapshot panic: %v\n%s", rec, debug.Stack())
+		}
+	}()
+
+	c.err = s.persist(op, func() error { return s.writeSnapshot(c) })
+}
+
+func (s *Server) waitSnapshot(c *snapshotCall) error {
+	if s.cfg.SnapshotTimeout <= 0 {
+		<-c.done
+		return c.err
+	}
+
+	t := time.NewTimer(s.cfg.SnapshotTimeout)
+	defer t.Stop()
+	select {
+	case <-c.done:
+		return c.err
+	case <-t.C:
+		if c.abort.CompareAndSwap(false, true) {
+			s.snap.timeouts.Add(1)
+			logger.Printf("ERROR: snapshot did not finish within %s, aborting it\n", s.cfg.SnapshotTimeout)
+		}
+		return fmt.Errorf("%w after %s", errSnapshotTimeout, s.cfg.SnapshotTimeout)
+	}
+}
+
+// periodicSnapshot is the worker's snapshot tick. It starts a snapshot and
+// returns without waiting for it, so slow disks never hold up expiry sweeps
+// or stats; a tick that finds the previous snapshot still running is skipped
+// rather than queued behind it.
+func (s *Server) periodicSnapshot() {
+	s.snap.mu.Lock()
+	running := s.snap.running != nil
+	s.snap.mu.Unlock()
+	if running {
+		s.snap.skipped.Add(1)
+		logger.Println("Snapshot still running, skipping this tick")
+		return
+	}
+
+	c, err := s.startSnapshot("snapshot", false)
+	var tooSoon *snapshotTooSoonError
+	switch {
+	case errors.As(err, &tooSoon):
+		return
+	case err != nil:
+		logger.Println("Snapshot error:", err)
+		return
+	}
+	go func() {
+		if err := s.waitSnapshot(c); err != nil {
+			logger.Println("Snapshot error:", err)
+		}
+	}()
+}
+
+// Snapshot files are versioned. Version 1, written by older releases, is a
+// bare JSON object of key to value. Version 2 keeps each entry's
+// modification time and expiry, under a header naming the format, and
+// version 3 adds the lists:
+//
+//	{"format": "web_server-snapshot", "version": 3, "created": time,
+//	 "entries": {"<key>": {"value": "...", "modified": time, "expires_at": time or omitted}},
+//	 "lists": {"<key>": ["...", ...]}}
+//
+// Older versions are migrated on load and rewritten as the current version by
+// the next snapshot; newer ones are refused rather than misread.
+const (
+	snapshotFormat  = "web_server-snapshot"
+	snapshotVersion = 3
+)
+
+type snapshotFile struct {
+	Format  string                 `json:"format"`
+	Version int                    `json:"version"`
+	Created time.Time              `json:"created"`
+	Entries map[string]backupEntry `json:"entries"`
+	Lists   map[string][]string    `json:"lists,omitempty"`
+}
+
+// liveDataLocked copies the unexpired values out of the store. Serializing
+// the copy after releasing s.mu keeps writers blocked only for the copy, and
+// no encoder ever walks the live map while a writer changes it. The caller
+// must hold s.mu.
+func (s *Server) liveDataLocked(now time.Time) map[string]string {
+	copyData := make(map[string]string, s.data.Len())
+	s.data.Range(func(k string, e entry) bool {
+		if !e.expired(now) {
+			copyData[k] = e.Value
+		}
+		return true
+	})
+	return copyData
+}
+
+// snapshotContents copies the live entries and lists into a snapshot file.
+func (s *Server) snapshotContents(now time.Time) snapshotFile {
+	file := snapshotFile{Format: snapshotFormat, Version: snapshotVersion, Created: now.UTC()}
+	s.lists.mu.Lock()
+	defer s.lists.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	file.Entries = make(map[string]backupEntry, s.data.Len())
+	s.data.Range(func(k string, e entry) bool {
+		if !e.expired(now) {
+			file.Entries[k] = backupEntryOf(e)
+		}
+		return true
+	})
+	file.Lists = s.copyListsLocked()
+	return file
+}
+
+func (s *Server) writeSnapshot(c *snapshotCall) error {
+	b, err := json.Marshal(s.snapshotContents(time.Now()))
+	if err != nil {
+		return err
+	}
+
+	path := s.cfg.SnapshotPath
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	// The abort check and the rename happen together under snap.mu, which
+	// a final snapshot takes before starting, so an aborted writer can
+	// never rename its older file over the final one.
+	s.snap.mu.Lock()
+	defer s.snap.mu.Unlock()
+	if c.abort.Load() {
+		return errSnapshotTimeout
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Server) loadSnapshot() (int, error) {
+	if s.cfg.SnapshotPath == "" {
+		return 0, nil
+	}
+
+	b, err := os.ReadFile(s.cfg.SnapshotPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	file, err := parseSnapshot(b)
+	if err != nil {
+		return 0, fmt.Errorf("parse snapshot %s: %w", s.cfg.SnapshotPath, err)
+	}
+	if file.Version < snapshotVersion {
+		logger.Printf("Migrating snapshot %s from version %d to %d; the next snapshot writes version %d\n", s.cfg.SnapshotPath, file.Version, snapshotVersion, snapshotVersion)
+	}
+
+	now := time.Now()
+	n := 0
+	s.lists.mu.Lock()
+	s.mu.Lock()
+	for k, be := range file.Entries {
+		e := be.entry()
+		if e.expired(now) {
+			continue
+		}
+		s.setEntryLocked(k, e)
+		n++
+	}
+	for k, list := range file.Lists {
+		s.setListLocked(k, list)
+		n++
+	}
+	s.mu.Unlock()
+	s.lists.mu.Unlock()
+
+	return n, nil
+}
+
+// setEntryLocked stores a copied entry with its expiry, keeping its
+// modification time when it has one. The caller must hold s.mu.
+func (s *Server) setEntryLocked(key string, e entry) {
+	s.setLocked(key, e.Value, e.ExpiresAt)
+	if !e.Modified.IsZero() {
+		stored, _ := s.data.Get(key)
+		stored.Modified = e.Modified
+		s.data.Set(key, stored)
+	}
+}
+
+// parseSnapshot reads a snapshot file of any known version, migrating it to
+// the current layout.
+func parseSnapshot(b []byte) (snapshotFile, error) {
+	var header struct {
+		Format  string `json:"format"`
+		Version int    `json:"version"`
+	}
+	if json.Unmarshal(b, &header) == nil && header.Format == snapshotFormat {
+		if header.Version < 2 || header.Version > snapshotVersion {
+			return snapshotFile{}, fmt.Errorf("unsupported snapshot version %d (this build reads up to %d)", header.Version, snapshotVersion)
+		}
+		var file snapshotFile
+		err := json.Unmarshal(b, &file)
+		return file, err
+	}
+	return migrateSnapshotV1(b)
+}
+
+// migrateSnapshotV1 upgrades a version 1 snapshot. It carried no times, so
+// migrated entries never expire and count as modified when loaded.
+func migrateSnapshotV1(b []byte) (snapshotFile, error) {
+	var data map[string]string
+	if err := json.Unmarshal(b, &data); err != nil {
+		return snapshotFile{}, err
+	}
+	file := snapshotFile{Format: snapshotFormat, Version: 1, Entries: make(map[string]backupEntry, len(data))}
+	for k, v := range data {
+		file.Entries[k] = backupEntry{Value: v}
+	}
+	return file, nil
+}
+
+func (s *Server) snapshotHandler(w http.ResponseWriter, r *http.Request) {
+	err := s.snapshot()
+	var tooSoon *snapshotTooSoonError
+	switch {
+	case errors.Is(err, errSnapshotDisabled):
+		writeError(w, http.StatusConflict, codeSnapshotsDisabled, "Snapshots are disabled")
+		return
+	case errors.As(err, &tooSoon):
+		w.Header().Set("Retry-After", strconv.Itoa(int(tooSoon.retryAfter.Seconds())+1))
+		writeError(w, http.StatusTooManyRequests, codeRateLimited, err.Error())
+		return
+	case err != nil:
+		logger.Println("Snapshot error:", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Snapshot failed")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// checkSnapshotDir verifies that the directory holding path exists and is
+// writable, so a misconfigured -snapshot-path fails at startup instead of at
+// the first snapshot. A world-writable directory is allowed but warned about.
+func checkSnapshotDir(path string) error {
+	dir := filepath.Dir(path)
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("snapshot directory %s: %w", dir, err)
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("snapshot directory %s is not a directory", dir)
+	}
+
+	probe, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return fmt.Errorf("snapshot directory %s is not writable: %w", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+
+	if fi.Mode().Perm()&0o002 != 0 {
+		logger.Printf("Warning: snapshot directory %s is world-writable (%s)\n", dir, fi.Mode().Perm())
+	}
+	return nil
+}
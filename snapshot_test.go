@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSnapshotDuringWrites takes snapshots while clients write key pairs in
+// single requests, and checks every snapshot holds whole pairs only.
+func TestSnapshotDuringWrites(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.json")
+	s, ts := newTestServer(t, "-snapshot-path", path, "-snapshot-min-interval", "0")
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; ; i++ {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				v := fmt.Sprintf("%d-%d", w, i)
+				body := fmt.Sprintf(`{"a%d":%q,"b%d":%q}`, w, v, w, v)
+				resp, err := ts.Client().Post(ts.URL+"/api/data", "application/json", strings.NewReader(body))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					t.Errorf("POST status %d", resp.StatusCode)
+					return
+				}
+			}
+		}(w)
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := s.snapshot(); err != nil {
+			t.Fatal(err)
+		}
+		restored, _ := newTestServer(t, "-snapshot-path", path)
+		if _, err := restored.loadSnapshot(); err != nil {
+			t.Fatal(err)
+		}
+		for w := 0; w < 4; w++ {
+			a, okA := restored.data.Get(fmt.Sprintf("a%d", w))
+			b, okB := restored.data.Get(fmt.Sprintf("b%d", w))
+			if okA != okB || a.Value != b.Value {
+				t.Fatalf("snapshot %d split a write: a%d=%q b%d=%q", i, w, a.Value, w, b.Value)
+			}
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(stop)
+	wg.Wait()
+}
+
+func BenchmarkSnapshotDuringWrites(b *testing.B) {
+	path := filepath.Join(b.TempDir(), "snap.json")
+	s, _ := newTestServer(b, "-snapshot-path", path, "-snapshot-min-interval", "0")
+	fillKeys(s, 10000)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			s.mu.Lock()
+			s.setLocked(fmt.Sprintf("key%d", i%10000), "changed", time.Time{})
+			s.mu.Unlock()
+		}
+	}()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := s.snapshot(); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.StopTimer()
+	close(stop)
+	<-done
+}
+
+func TestSnapshotV1Migration(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.json")
+	if err := os.WriteFile(path, []byte(`{"a":"1","b":"two"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, ts := newTestServer(t, "-snapshot-path", path)
+	n, err := s.loadSnapshot()
+	if err != nil || n != 2 {
+		t.Fatalf("loadSnapshot = %d, %v; want 2 keys", n, err)
+	}
+	if got := getValue(t, ts, "b"); got != "two" {
+		t.Fatalf("b = %q, want two", got)
+	}
+	if e, _ := s.data.Get("a"); !e.ExpiresAt.IsZero() || e.Modified.IsZero() {
+		t.Fatalf("migrated entry %+v: want no expiry and a modified time", e)
+	}
+	if s.dataBytes != len("a1")+len("btwo") {
+		t.Fatalf("db_bytes %d after migration, want %d", s.dataBytes, len("a1")+len("btwo"))
+	}
+
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var file snapshotFile
+	decodeBody(t, string(b), &file)
+	if file.Format != snapshotFormat || file.Version != snapshotVersion || file.Entries["b"].Value != "two" {
+		t.Fatalf("rewritten snapshot %s, want version %d", b, snapshotVersion)
+	}
+}
+
+func TestSnapshotRefusesNewerVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.json")
+	body := fmt.Sprintf(`{"format":%q,"version":%d,"entries":{}}`, snapshotFormat, snapshotVersion+1)
+	if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := newTestServer(t, "-snapshot-path", path)
+	if _, err := s.loadSnapshot(); err == nil || !strings.Contains(err.Error(), "unsupported snapshot version") {
+		t.Fatalf("loadSnapshot = %v, want an unsupported version error", err)
+	}
+}
+
+func TestSnapshotPanicIsRecovered(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "snap.json")
+	s, ts := newTestServer(t, "-snapshot-path", path, "-snapshot-min-interval", "0", "-persist-retries", "0")
+	store := &panicStore{memoryStore: newMemoryStore()}
+	store.panics.Store(1)
+	s.data = store
+
+	if err := s.snapshot(); err == nil || !strings.Contains(err.Error(), "panicked") {
+		t.Fatalf("snapshot = %v, want a panic error", err)
+	}
+	// s.mu was released and the next snapshot runs normally.
+	expect(t, ts, http.MethodPost, "/api/data", `{"k":"v"}`, http.StatusOK)
+	if err := s.snapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// staticHandler serves files from dir under /public/. It adds an ETag derived
+// from the file's modification time and size plus a Cache-Control max-age;
+// http.FileServer answers conditional requests against both with 304.
+func staticHandler(dir string, maxAge time.Duration) http.HandlerFunc {
+	fs := http.StripPrefix("/public/", http.FileServer(http.Dir(dir)))
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := path.Clean("/" + strings.TrimPrefix(r.URL.Path, "/public/"))
+		if fi, err := os.Stat(filepath.Join(dir, filepath.FromSlash(name))); err == nil && !fi.IsDir() {
+			w.Header().Set("ETag", fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()))
+			if maxAge > 0 {
+				w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds())))
+			} else {
+				w.Header().Set("Cache-Control", "no-cache")
+			}
+		}
+		fs.ServeHTTP(w, r)
+	}
+}
+
+// faviconHandler serves file as the site icon. Without a file it answers 204
+// so browsers stop asking without filling the log with 404s.
+func faviconHandler(file string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if file == "" {
+			w.Header().Set("Cache-Control", "public, max-age=86400")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		http.ServeFile(w, r, file)
+	}
+}
+
+// defaultRobots keeps crawlers out entirely; this is an API server.
+const defaultRobots = "User-agent: *\nDisallow: /\n"
+
+func robotsHandler(file string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if file == "" {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			w.Write([]byte(defaultRobots))
+			return
+		}
+		http.ServeFile(w, r, file)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import "time"
+
+type entry struct {
+	Value     string
+	Modified  time.Time
+	ExpiresAt time.Time
+	// Version is the data version of the write that stored this entry.
+	Version uint64
+}
+
+// Store is the storage backend behind the handlers. Implementations do not
+// need to be safe for concurrent use: the Server serializes every call under
+// its own lock so multi-key operations stay atomic.
+type Store interface {
+	Get(key string) (entry, bool)
+	Set(key string, e entry)
+	Delete(key string) bool
+	// Range calls fn for every entry until fn returns false. fn must not
+	// modify the store.
+	Range(fn func(key string, e entry) bool)
+	Len() int
+}
+
+// newStore returns an empty store for a new server or a restore to fill.
+func newStore() Store {
+	return newMemoryStore()
+}
+
+type memoryStore struct {
+	data map[string]entry
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{data: make(map[string]entry)}
+}
+
+func (m *memoryStore) Get(key string) (entry, bool) {
+	e, ok := m.data[key]
+	return e, ok
+}
+
+func (m *memoryStore) Set(key string, e entry) {
+	m.data[key] = e
+}
+
+func (m *memoryStore) Delete(key string) bool {
+	if _, ok := m.data[key]; !ok {
+		return false
+	}
+	delete(m.data, key)
+	return true
+}
+
+func (m *memoryStore) Range(fn func(key string, e entry) bool) {
+	for k, e := range m.data {
+		if !fn(k, e) {
+			return
+		}
+	}
+}
+
+func (m *memoryStore) Len() int {
+	return len(m.data)
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"testing"
+	"time"
+)
+
+// stores lists every Store implementation; each one runs the same suite.
+var stores = map[string]func() Store{
+	"memory": func() Store { return newMemoryStore() },
+}
+
+func TestStoreContract(t *testing.T) {
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			st := newStore()
+			if _, ok := st.Get("missing"); ok || st.Len() != 0 {
+				t.Fatal("new store is not empty")
+			}
+
+			e := entry{Value: "v", Modified: time.Unix(1, 0), ExpiresAt: time.Unix(2, 0), Version: 3}
+			st.Set("a", e)
+			st.Set("b", entry{Value: "w"})
+			if got, ok := st.Get("a"); !ok || got != e {
+				t.Fatalf("Get(a) = %+v, %v; want %+v", got, ok, e)
+			}
+			st.Set("b", entry{Value: "x"})
+			if got, _ := st.Get("b"); got.Value != "x" || st.Len() != 2 {
+				t.Fatalf("overwrite: Get(b) = %+v, Len %d", got, st.Len())
+			}
+
+			var keys []string
+			st.Range(func(k string, _ entry) bool {
+				keys = append(keys, k)
+				return true
+			})
+			sort.Strings(keys)
+			if len(keys) != 2 || keys[0] != "a" || keys[1] != "b" {
+				t.Fatalf("Range visited %q", keys)
+			}
+			visited := 0
+			st.Range(func(string, entry) bool {
+				visited++
+				return false
+			})
+			if visited != 1 {
+				t.Fatalf("Range kept going after false: %d calls", visited)
+			}
+
+			if !st.Delete("a") || st.Delete("a") {
+				t.Fatal("Delete should report true once, then false")
+			}
+			if _, ok := st.Get("a"); ok || st.Len() != 1 {
+				t.Fatalf("after Delete: Len %d", st.Len())
+			}
+		})
+	}
+}
+
+func TestStoreBehindHandlers(t *testing.T) {
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			s, ts := newTestServer(t)
+			s.data = newStore()
+
+			expect(t, ts, http.MethodPost, "/api/data", `{"a":"1","b":"2"}`, http.StatusOK)
+			expect(t, ts, http.MethodPut, "/api/data/a", `{"value":"3"}`, http.StatusOK)
+			if got := getValue(t, ts, "a"); got != "3" {
+				t.Fatalf("a = %q, want 3", got)
+			}
+			expect(t, ts, http.MethodDelete, "/api/data/b", "", http.StatusOK)
+			expect(t, ts, http.MethodGet, "/api/data/b", "", http.StatusNotFound)
+
+			var all map[string]string
+			decodeBody(t, expect(t, ts, http.MethodGet, "/api/data", "", http.StatusOK), &all)
+			if len(all) != 1 || all["a"] != "3" {
+				t.Fatalf("GET /api/data = %v, want map[a:3]", all)
+			}
+			if s.dataBytes != len("a")+len("3") {
+				t.Fatalf("db_bytes %d, want %d", s.dataBytes, len("a")+len("3"))
+			}
+		})
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"io"
+)
+
+func openSyslog() (io.Writer, error) {
+	return nil, errors.New("not supported on this platform")
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+)
+
+// valueTransforms are the write hooks selectable with -transforms. They run
+// in the configured order on every value written through POST or PUT.
+var valueTransforms = map[string]func(value string, now time.Time) string{
+	"trim":      func(v string, _ time.Time) string { return strings.TrimSpace(v) },
+	"lowercase": func(v string, _ time.Time) string { return strings.ToLower(v) },
+	// updated_at adds an "_updated_at" field to values holding a JSON
+	// object and leaves every other value untouched.
+	"updated_at": func(v string, now time.Time) string {
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal([]byte(v), &obj); err != nil || obj == nil {
+			return v
+		}
+		ts, _ := json.Marshal(now.UTC().Format(time.RFC3339))
+		obj["_updated_at"] = ts
+		b, err := json.Marshal(obj)
+		if err != nil {
+			return v
+		}
+		return string(b)
+	},
+}
+
+func (s *Server) transformValue(v string, now time.Time) string {
+	for _, name := range s.cfg.Transforms {
+		v = valueTransforms[name](v, now)
+	}
+	return v
+}
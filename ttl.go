@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var errInvalidTTL = errors.New("ttl must be a positive number of seconds or -1")
+
+func (e entry) expired(now time.Time) bool {
+	return !e.ExpiresAt.IsZero() && !now.Before(e.ExpiresAt)
+}
+
+// expiryFromRequest resolves the expiry for a write. A ?ttl= query parameter
+// takes precedence over -default-ttl: ttl=N expires the key after N seconds
+// and ttl=-1 keeps it forever. Without the parameter the default TTL applies,
+// where 0 means never expire. The zero time means no expiry.
+func (s *Server) expiryFromRequest(r *http.Request) (time.Time, error) {
+	if v := r.URL.Query().Get("ttl"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n == 0 || n < -1 {
+			return time.Time{}, errInvalidTTL
+		}
+		if n == -1 {
+			return time.Time{}, nil
+		}
+		return time.Now().Add(time.Duration(n) * time.Second), nil
+	}
+	if s.cfg.DefaultTTL > 0 {
+		return time.Now().Add(s.cfg.DefaultTTL), nil
+	}
+	return time.Time{}, nil
+}
+
+// sweepExpired removes every expired key and returns how many were removed.
+// The lock is released by a defer so a panic recovered by the worker does
+// not leave it held.
+func (s *Server) sweepExpired() int {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var expired []string
+	s.data.Range(func(k string, e entry) bool {
+		if e.expired(now) {
+			expired = append(expired, k)
+		}
+		return true
+	})
+	for _, k := range expired {
+		s.deleteLocked(k)
+		s.notifier.publish(changeEvent{Op: "expire", Key: k, Time: now})
+	}
+	s.expiredKeys += len(expired)
+	return len(expired)
+}
+
+// ttlSeconds mirrors Redis TTL: the remaining lifetime in whole seconds, -1
+// for a key without expiry and -2 for a missing or expired key.
+func ttlSeconds(e entry, ok bool, now time.Time) int {
+	if !ok || e.expired(now) {
+		return -2
+	}
+	if e.ExpiresAt.IsZero() {
+		return -1
+	}
+	return int(math.Ceil(e.ExpiresAt.Sub(now).Seconds()))
+}
+
+// getTTLHandler follows Redis and answers 200 with ttl -2 for missing keys
+// rather than 404, so clients can poll a key's lifetime without treating
+// its disappearance as an error.
+func (s *Server) getTTLHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	s.lock()
+	s.incRequests(key)
+	e, found := s.data.Get(key)
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "ttl": ttlSeconds(e, found, now)})
+}
+
+func (s *Server) putTTLHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		TTL *int `json:"ttl"`
+	}
+	if err := s.decodeJSONBody(r, &body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+	if body.TTL == nil || *body.TTL == 0 || *body.TTL < -1 {
+		writeError(w, http.StatusBadRequest, codeInvalidTTL, errInvalidTTL.Error())
+		return
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	if *body.TTL > 0 {
+		expiresAt = now.Add(time.Duration(*body.TTL) * time.Second)
+	}
+
+	s.writeExpiry(w, key, expiresAt, now)
+}
+
+// expireHandler sets a key's expiry like Redis EXPIRE and EXPIREAT: the body
+// is either {"in": seconds} or {"at": RFC 3339 time}, and must name a moment
+// in the future.
+func (s *Server) expireHandler(w http.ResponseWriter, r *http.Request) {
+	key, ok := s.keyFromPath(w, r)
+	if !ok {
+		return
+	}
+
+	var body struct {
+		In *int       `json:"in"`
+		At *time.Time `json:"at"`
+	}
+	if err := s.decodeJSONBody(r, &body); err != nil {
+		writeDecodeError(w, err)
+		return
+	}
+
+	now := time.Now()
+	var expiresAt time.Time
+	switch {
+	case (body.In == nil) == (body.At == nil):
+		writeError(w, http.StatusBadRequest, codeInvalidTTL, `Exactly one of "in" and "at" is required`)
+		return
+	case body.In != nil:
+		if *body.In <= 0 {
+			writeError(w, http.StatusBadRequest, codeInvalidTTL, `"in" must be a positive number of seconds`)
+			return
+		}
+		expiresAt = now.Add(time.Duration(*body.In) * time.Second)
+	default:
+		if !body.At.After(now) {
+			writeError(w, http.StatusBadRequest, codeInvalidTTL, `"at" must be in the future`)
+			return
+		}
+		expiresAt = *body.At
+	}
+
+	s.writeExpiry(w, key, expiresAt, now)
+}
+
+// writeExpiry sets the expiry of a live key in one critical section and
+// answers with its new TTL, or 404 when the key is missing or expired. Like
+// any write it bumps the data version and publishes a "ttl" change event.
+func (s *Server) writeExpiry(w http.ResponseWriter, key string, expiresAt, now time.Time) {
+	s.lock()
+	s.incRequests(key)
+	e, found := s.data.Get(key)
+	live := found && !e.expired(now)
+	if live {
+		s.version++
+		e.ExpiresAt = expiresAt
+		e.Version = s.version
+		s.data.Set(key, e)
+
+		ev := changeEvent{Op: "ttl", Key: key, Time: now}
+		if !expiresAt.IsZero() {
+			ev.ExpiresAt = &expiresAt
+		}
+		s.notifier.publish(ev)
+	}
+	s.mu.Unlock()
+
+	if !live {
+		writeError(w, http.StatusNotFound, codeKeyNotFound, "Key not found")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"key": key, "ttl": ttlSeconds(e, true, now)})
+}
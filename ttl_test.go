@@ -0,0 +1,43 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTTLChangeBumpsVersionAndNotifies(t *testing.T) {
+	s, ts := newTestServer(t)
+	events := make(chan changeEvent, 8)
+	s.notifier.addSink(func(ev changeEvent) { events <- ev })
+
+	expect(t, ts, http.MethodPost, "/api/data", `{"k":"v"}`, http.StatusOK)
+	<-events
+	resp, _ := do(t, ts, http.MethodGet, "/api/data", "")
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("GET /api/data sent no ETag")
+	}
+
+	expect(t, ts, http.MethodPut, "/api/data/k/ttl", `{"ttl":60}`, http.StatusOK)
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL+"/api/data", nil)
+	req.Header.Set("If-None-Match", etag)
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("stale ETag after a TTL change got %d, want 200", resp.StatusCode)
+	}
+
+	select {
+	case ev := <-events:
+		if ev.Op != "ttl" || ev.Key != "k" || ev.ExpiresAt == nil {
+			t.Fatalf("got event %+v, want a ttl event for k with an expiry", ev)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("no change event for the TTL change")
+	}
+}
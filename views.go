@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+)
+
+func serveView(path string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, path)
+	}
+}
+
+// rootHandler serves the index page to browsers and a JSON list of the API
+// endpoints to clients that prefer application/json over text/html.
+func rootHandler(rt *router, indexFile string) http.HandlerFunc {
+	page := serveView(indexFile)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+		if !prefersJSON(r.Header.Get("Accept")) {
+			page(w, r)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"endpoints": rt.endpoints("/api/", "/metrics"),
+		})
+	}
+}
+
+func prefersJSON(accept string) bool {
+	jsonQ, htmlQ := -1.0, -1.0
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, q := parseCoding(part)
+		switch mediaType {
+		case "application/json":
+			jsonQ = q
+		case "text/html":
+			htmlQ = q
+		}
+	}
+	return jsonQ > 0 && jsonQ > htmlQ
+}
+
+func parseStatsPage(path string) (*template.Template, error) {
+	tmpl, err := template.ParseFiles(path)
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return tmpl, nil
+}
+
+// statsPageHandler renders the stats page with the current numbers already
+// filled in, so it is useful without JavaScript. The page script keeps
+// refreshing them when JavaScript is available.
+func (s *Server) statsPageHandler(w http.ResponseWriter, r *http.Request) {
+	stats, err := json.MarshalIndent(s.stats(), "", "  ")
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := s.statsTmpl.Execute(&buf, struct{ Stats string }{string(stats)}); err != nil {
+		logger.Println("Render stats page:", err)
+		writeError(w, http.StatusInternalServerError, codeInternal, "Internal server error")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(buf.Bytes())
+}
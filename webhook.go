@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// webhookAttempts is how often a delivery is tried before it is given up,
+// waiting webhookBackoff, then twice that, between attempts.
+const (
+	webhookAttempts = 3
+	webhookBackoff  = 500 * time.Millisecond
+)
+
+// webhooks POSTs every change event, as the JSON also sent on /api/events,
+// to each -webhooks URL. It is a notifier sink that only queues, so a slow
+// or failing endpoint holds up neither writes nor the other sinks; a single
+// worker delivers in order, retrying failures, and events arriving while the
+// queue is full are dropped and counted.
+//
+// With -webhook-secret set each request carries
+//
+//	X-Webhook-Signature: sha256=<hex HMAC-SHA256 of the body>
+//
+// so receivers can check that it came from this server.
+type webhooks struct {
+	urls   []string
+	secret []byte
+	client *http.Client
+	queue  chan changeEvent
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+
+	sent     atomic.Int64
+	failures atomic.Int64
+	dropped  atomic.Int64
+}
+
+func newWebhooks(urls []string, secret string, queueSize int) *webhooks {
+	return &webhooks{
+		urls:   urls,
+		secret: []byte(secret),
+		client: &http.Client{Timeout: 5 * time.Second},
+		queue:  make(chan changeEvent, queueSize),
+		done:   make(chan struct{}),
+	}
+}
+
+// enqueue is the notifier sink.
+func (wh *webhooks) enqueue(ev changeEvent) {
+	wh.mu.Lock()
+	defer wh.mu.Unlock()
+	if wh.closed {
+		return
+	}
+	select {
+	case wh.queue <- ev:
+	default:
+		wh.dropped.Add(1)
+	}
+}
+
+func (wh *webhooks) start() {
+	go func() {
+		defer close(wh.done)
+		for ev := range wh.queue {
+			body, _ := json.Marshal(ev)
+			for _, u := range wh.urls {
+				if err := wh.deliver(u, body); err != nil {
+					wh.failures.Add(1)
+					logger.Printf("Webhook %s failed for %s %q: %v\n", u, ev.Op, ev.Key, err)
+					continue
+				}
+				wh.sent.Add(1)
+			}
+		}
+	}()
+}
+
+func (wh *webhooks) deliver(url string, body []byte) error {
+	var err error
+	backoff := webhookBackoff
+	for attempt := 1; attempt <= webhookAttempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(backoff)
+			backoff *= 2
+		}
+		if err = wh.post(url, body); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func (wh *webhooks) post(url string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if len(wh.secret) > 0 {
+		mac := hmac.New(sha256.New, wh.secret)
+		mac.Write(body)
+		req.Header.Set("X-Webhook-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	}
+	return nil
+}
+
+// close stops accepting events and waits for queued ones to be delivered.
+func (wh *webhooks) close() error {
+	wh.mu.Lock()
+	if !wh.closed {
+		wh.closed = true
+		close(wh.queue)
+	}
+	wh.mu.Unlock()
+	<-wh.done
+	return nil
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"runtime/debug"
+	"time"
+)
+
+func (s *Server) startBackgroundWorker() {
+	defer close(s.workerDone)
+	for !s.runWorker() {
+		logger.Println("Restarting worker")
+	}
+	logger.Println("Worker Stopped")
+}
+
+// runWorker runs the tick loop until shutdown. It returns false when the loop
+// was aborted by a recovered panic and should be started again.
+func (s *Server) runWorker() (stopped bool) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			if s.cfg.WorkerPanicMode == "crash" {
+				panic(rec)
+			}
+			s.workerPanics.Add(1)
+			logger.Printf("Worker panic: %v\n%s", rec, debug.Stack())
+			stopped = false
+		}
+	}()
+
+	ticker := time.NewTicker(s.cfg.WorkerInterval)
+	defer ticker.Stop()
+
+	var snapshotC <-chan time.Time
+	if s.cfg.SnapshotPath != "" && s.cfg.SnapshotInterval > 0 {
+		snapshotTicker := time.NewTicker(s.cfg.SnapshotInterval)
+		defer snapshotTicker.Stop()
+		snapshotC = snapshotTicker.C
+	}
+
+	lastRequests, lastSize := -1, -1
+	var idleNoted time.Duration
+	for {
+		select {
+		case <-ticker.C:
+			s.sweepExpired()
+			s.checkKeyCount()
+			s.sweepRateLimits()
+			if n := s.sweepUploads(); n > 0 {
+				logger.Printf("Dropped %d abandoned uploads\n", n)
+			}
+			lastRequests, lastSize = s.logStats(lastRequests, lastSize)
+			idleNoted = s.logIdle(idleNoted)
+			s.publishStats()
+		case <-snapshotC:
+			s.periodicSnapshot()
+		case <-s.shutdownCh:
+			return true
+		}
+	}
+}
+
+// logIdle logs once each time the server has gone another -idle-log-after
+// without a request, and returns the idle time logged so far in the current
+// stretch, which drops back to zero once a request arrives.
+func (s *Server) logIdle(noted time.Duration) time.Duration {
+	if s.cfg.IdleLogAfter <= 0 {
+		return 0
+	}
+	idle := time.Since(time.Unix(0, s.lastRequest.Load()))
+	reached := idle / s.cfg.IdleLogAfter * s.cfg.IdleLogAfter
+	if reached == 0 || reached == noted {
+		return reached
+	}
+	logger.Printf("INFO: idle for %s\n", reached)
+	return reached
+}
+
+// Key count alert levels, reported as the key_alert_level gauge.
+const (
+	keyAlertNone = iota
+	keyAlertWarning
+	keyAlertCritical
+)
+
+// checkKeyCount compares the number of keys against -keys-warn and
+// -keys-critical and logs when the level changes, including when the count
+// drops back below a threshold. Below the warning level it stays quiet.
+func (s *Server) checkKeyCount() {
+	s.mu.Lock()
+	n := s.data.Len()
+	s.mu.Unlock()
+
+	level, threshold := keyAlertNone, 0
+	switch {
+	case s.cfg.KeysCritical > 0 && n >= s.cfg.KeysCritical:
+		level, threshold = keyAlertCritical, s.cfg.KeysCritical
+	case s.cfg.KeysWarn > 0 && n >= s.cfg.KeysWarn:
+		level, threshold = keyAlertWarning, s.cfg.KeysWarn
+	}
+
+	prev := s.keyAlertLevel.Swap(int64(level))
+	if int64(level) == prev {
+		return
+	}
+	switch level {
+	case keyAlertCritical:
+		logger.Printf("ERROR: %d keys stored, at or above the critical threshold of %d\n", n, threshold)
+	case keyAlertWarning:
+		logger.Printf("WARN: %d keys stored, at or above the warning threshold of %d\n", n, threshold)
+	default:
+		logger.Printf("Key count back to %d, below the warning threshold\n", n)
+	}
+}
+
+// logStats prints the request count and database size when either changed
+// since the previous tick, or on every tick with -verbose-stats.
+func (s *Server) logStats(lastRequests, lastSize int) (int, int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	requests, size := s.requests, s.data.Len()
+	if s.cfg.VerboseStats || requests != lastRequests || size != lastSize {
+		logger.Printf("Current Requests: %d, Database size: %d\n", requests, size)
+	}
+	return requests, size
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// panicStore panics from Range while panics is above zero.
+type panicStore struct {
+	*memoryStore
+	panics atomic.Int32
+}
+
+func (p *panicStore) Range(fn func(key string, e entry) bool) {
+	if p.panics.Add(-1) >= 0 {
+		panic("injected store failure")
+	}
+	p.memoryStore.Range(fn)
+}
+
+func TestWorkerRecoversFromPanic(t *testing.T) {
+	s, ts := newTestServer(t, "-worker-interval", "10ms")
+	store := &panicStore{memoryStore: newMemoryStore()}
+	store.panics.Store(1)
+	s.data = store
+
+	s.mu.Lock()
+	s.setEntryLocked("old", entry{Value: "v", ExpiresAt: time.Now().Add(-time.Second)})
+	s.mu.Unlock()
+
+	go s.startBackgroundWorker()
+	defer func() {
+		close(s.shutdownCh)
+		<-s.workerDone
+	}()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		s.mu.Lock()
+		_, ok := s.data.Get("old")
+		s.mu.Unlock()
+		if !ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("restarted worker never swept the expired key")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var stats map[string]interface{}
+	decodeBody(t, expect(t, ts, http.MethodGet, "/api/stats", "", http.StatusOK), &stats)
+	if got := stats["worker_panics"]; got != float64(1) {
+		t.Fatalf("worker_panics = %v, want 1", got)
+	}
+}